@@ -0,0 +1,172 @@
+package memory_cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+//访问trace：按key抽样记录Value/Add/Delete操作，用于离线分析(例如cmd/cachesim)
+
+type TraceOp uint8
+
+const (
+	TraceValue  TraceOp = iota + 1 //Value，Hit表示是否命中
+	TraceAdd                       //Add、Put等写入，Hit表示是否覆盖了已有的key
+	TraceDelete                    //Delete，Hit表示key是否存在
+)
+
+func (op TraceOp) String() string {
+	switch op {
+	case TraceValue:
+		return "value"
+	case TraceAdd:
+		return "add"
+	case TraceDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type TraceRecord struct {
+	Time    time.Time
+	KeyHash uint64 //跨进程稳定的key hash，不记录key本身
+	Op      TraceOp
+	Size    int //[]byte和string类型的value长度，其他类型为0
+	Hit     bool
+}
+
+const DefaultTraceCapacity = 64 * 1024
+
+type TraceOptions struct {
+	Capacity   int     //环形缓冲区的大小，满了之后覆盖最旧的记录，<=0时使用DefaultTraceCapacity
+	SampleRate float64 //(0,1]，按key hash抽样，同一个key的操作要么全部记录要么都不记录，<=0时为1
+}
+
+type accessTracer struct {
+	threshold uint64 //key hash不大于threshold的才记录
+
+	mu      sync.Mutex
+	records []TraceRecord
+	next    int
+	full    bool
+}
+
+//开启访问trace，已经开启时按新的参数重新开始记录
+func (table *CacheTable) EnableTrace(opts TraceOptions) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultTraceCapacity
+	}
+	threshold := uint64(math.MaxUint64)
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		threshold = uint64(opts.SampleRate * math.MaxUint64)
+	}
+	tracer := &accessTracer{
+		threshold: threshold,
+		records:   make([]TraceRecord, opts.Capacity),
+	}
+	table.Lock()
+	table.tracer = tracer
+	table.Unlock()
+}
+
+//关闭访问trace并丢弃已记录的数据
+func (table *CacheTable) DisableTrace() {
+	table.Lock()
+	table.tracer = nil
+	table.Unlock()
+}
+
+//按时间顺序返回缓冲区中的记录
+func (table *CacheTable) TraceRecords() ([]TraceRecord, error) {
+	table.RLock()
+	tracer := table.tracer
+	table.RUnlock()
+	if tracer == nil {
+		return nil, ErrTraceDisabled
+	}
+	return tracer.snapshot(), nil
+}
+
+//把缓冲区中的记录以CSV格式写入w，第一行为表头：
+//
+//	timestamp,key,op,size,hit
+//
+//timestamp为Unix秒(小数部分精确到纳秒)，key为16位十六进制的key hash，
+//op为value、add或delete，size为value的字节数，hit为1或0
+//cmd/cachesim可以直接读取该格式
+func (table *CacheTable) DumpTrace(w io.Writer) error {
+	records, err := table.TraceRecords()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "timestamp,key,op,size,hit")
+	for _, r := range records {
+		hit := 0
+		if r.Hit {
+			hit = 1
+		}
+		ns := r.Time.UnixNano()
+		fmt.Fprintf(bw, "%d.%09d,%016x,%s,%d,%d\n", ns/1e9, ns%1e9, r.KeyHash, r.Op, r.Size, hit)
+	}
+	return bw.Flush()
+}
+
+//tracer为nil时什么也不做，调用方不需要持有table的锁
+func (tracer *accessTracer) record(op TraceOp, key, data interface{}, hit bool) {
+	if tracer == nil {
+		return
+	}
+	h := traceHash(key)
+	if h > tracer.threshold {
+		return
+	}
+	r := TraceRecord{Time: time.Now(), KeyHash: h, Op: op, Hit: hit}
+	switch v := data.(type) {
+	case []byte:
+		r.Size = len(v)
+	case string:
+		r.Size = len(v)
+	}
+	tracer.mu.Lock()
+	tracer.records[tracer.next] = r
+	tracer.next++
+	if tracer.next == len(tracer.records) {
+		tracer.next = 0
+		tracer.full = true
+	}
+	tracer.mu.Unlock()
+}
+
+func (tracer *accessTracer) snapshot() []TraceRecord {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if !tracer.full {
+		return append([]TraceRecord(nil), tracer.records[:tracer.next]...)
+	}
+	records := make([]TraceRecord, 0, len(tracer.records))
+	records = append(records, tracer.records[tracer.next:]...)
+	return append(records, tracer.records[:tracer.next]...)
+}
+
+//与hashKey不同，不使用随机种子，不同进程导出的trace中同一个key的hash相同
+func traceHash(key interface{}) uint64 {
+	if h, ok := intHash(key); ok {
+		return h
+	}
+	s, ok := key.(string)
+	if !ok {
+		s = fmt.Sprint(key)
+	}
+	//FNV-1a
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
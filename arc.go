@@ -0,0 +1,156 @@
+package memory_cache
+
+import "container/list"
+
+//ARC(Adaptive Replacement Cache)：T1保存只访问过一次的key，T2保存访问过多次的key，
+//B1、B2分别记录最近从T1、T2淘汰的key(只有key没有value)。
+//在B1中命中说明T1太小，在B2中命中说明T2太小，p(T1的目标大小)据此自动调整，不需要手动调参。
+
+//key所在的链表
+const (
+	arcT1 = iota
+	arcT2
+	arcB1
+	arcB2
+)
+
+type arcEntry struct {
+	key   interface{}
+	where int
+}
+
+type arcPolicy struct {
+	capacity int
+	p        int //T1的目标大小
+	lists    [4]*list.List
+	elems    map[interface{}]*list.Element
+}
+
+func NewARCPolicy(capacity int) EvictionPolicy {
+	if capacity < 1 {
+		capacity = 1
+	}
+	p := &arcPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+//把key移动到目标链表的头部
+func (p *arcPolicy) move(e *list.Element, where int) {
+	entry := e.Value.(*arcEntry)
+	p.lists[entry.where].Remove(e)
+	entry.where = where
+	p.elems[entry.key] = p.lists[where].PushFront(entry)
+}
+
+//删除链表尾部的key，返回被删除的key
+func (p *arcPolicy) dropLRU(where int) interface{} {
+	back := p.lists[where].Back()
+	p.lists[where].Remove(back)
+	key := back.Value.(*arcEntry).key
+	delete(p.elems, key)
+	return key
+}
+
+func (p *arcPolicy) resident() int {
+	return p.lists[arcT1].Len() + p.lists[arcT2].Len()
+}
+
+//缓存满时淘汰T1或T2尾部的key，并记入对应的ghost链表
+func (p *arcPolicy) replace(inB2 bool) []interface{} {
+	if p.resident() < p.capacity {
+		return nil
+	}
+	t1 := p.lists[arcT1].Len()
+	from, ghost := arcT2, arcB2
+	if t1 > 0 && (t1 > p.p || inB2 && t1 == p.p) {
+		from, ghost = arcT1, arcB1
+	}
+	back := p.lists[from].Back()
+	if back == nil {
+		return nil
+	}
+	key := back.Value.(*arcEntry).key
+	p.move(back, ghost)
+	return []interface{}{key}
+}
+
+func (p *arcPolicy) Add(key interface{}) []interface{} {
+	e, ok := p.elems[key]
+	if ok {
+		entry := e.Value.(*arcEntry)
+		switch entry.where {
+		case arcT1, arcT2:
+			p.move(e, arcT2)
+			return nil
+		case arcB1: //T1淘汰得太早，增大T1的目标
+			delta := 1
+			if b1, b2 := p.lists[arcB1].Len(), p.lists[arcB2].Len(); b2 > b1 {
+				delta = b2 / b1
+			}
+			if p.p += delta; p.p > p.capacity {
+				p.p = p.capacity
+			}
+			evicted := p.replace(false)
+			p.move(e, arcT2)
+			return evicted
+		case arcB2: //T2淘汰得太早，减小T1的目标
+			delta := 1
+			if b1, b2 := p.lists[arcB1].Len(), p.lists[arcB2].Len(); b1 > b2 {
+				delta = b1 / b2
+			}
+			if p.p -= delta; p.p < 0 {
+				p.p = 0
+			}
+			evicted := p.replace(true)
+			p.move(e, arcT2)
+			return evicted
+		}
+	}
+
+	//完全没有见过的key
+	var evicted []interface{}
+	l1 := p.lists[arcT1].Len() + p.lists[arcB1].Len()
+	total := l1 + p.lists[arcT2].Len() + p.lists[arcB2].Len()
+	if l1 >= p.capacity {
+		if p.lists[arcT1].Len() < p.capacity {
+			p.dropLRU(arcB1)
+			evicted = p.replace(false)
+		} else { //B1为空，直接淘汰T1尾部且不记入ghost
+			evicted = []interface{}{p.dropLRU(arcT1)}
+		}
+	} else if total >= p.capacity {
+		if total >= 2*p.capacity {
+			p.dropLRU(arcB2)
+		}
+		evicted = p.replace(false)
+	}
+	p.elems[key] = p.lists[arcT1].PushFront(&arcEntry{key: key, where: arcT1})
+	return evicted
+}
+
+func (p *arcPolicy) Access(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		if where := e.Value.(*arcEntry).where; where == arcT1 || where == arcT2 {
+			p.move(e, arcT2)
+		}
+	}
+}
+
+//只移除缓存中的key，ghost记录保留
+func (p *arcPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		if entry := e.Value.(*arcEntry); entry.where == arcT1 || entry.where == arcT2 {
+			p.lists[entry.where].Remove(e)
+			delete(p.elems, key)
+		}
+	}
+}
+
+func (p *arcPolicy) Reset() {
+	p.p = 0
+	for i := range p.lists {
+		p.lists[i] = list.New()
+	}
+	p.elems = make(map[interface{}]*list.Element)
+}
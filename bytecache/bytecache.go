@@ -0,0 +1,280 @@
+//Package bytecache 提供面向[]byte的表，适合条目数量巨大、对GC停顿敏感的场景。
+//
+//key和value序列化后顺序写入每个分片预先分配好的环形arena，索引是不含指针的map[uint64]uint64(key的hash到arena中的位置)，
+//GC既不需要扫描条目也不需要扫描索引。arena写满后从头覆盖最早写入的条目，因此容量是按字节而不是按条目数限制的。
+//
+//与CacheTable不同，生命周期从写入时开始计算，读取不会延长生命周期。
+package bytecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const (
+	DefaultShards   = 256
+	DefaultCapacity = 64 << 20 //64MB
+
+	//条目头：过期时间(8字节，UnixNano，0表示永久有效)、key长度(2字节)、value长度(4字节)
+	headerSize = 8 + 2 + 4
+	maxKeySize = 1<<16 - 1
+	offsetBits = 32
+	offsetMask = 1<<offsetBits - 1
+)
+
+var ErrEntryTooLarge = errors.New("bytecache: entry is larger than a shard")
+
+type Config struct {
+	Shards   int //分片数量，会向上取整为2的幂，默认DefaultShards
+	Capacity int //所有分片arena的总字节数，默认DefaultCapacity
+}
+
+type Stats struct {
+	Entries    int //索引中的条目数，可能包括已过期但还没被清理的条目
+	Hits       uint64
+	Misses     uint64
+	Collisions uint64 //hash相同但key不同的次数
+	Overwrites uint64 //arena回绕的次数，每次回绕都会淘汰最早的一批条目
+}
+
+type Table struct {
+	shards []*shard
+	mask   uint64
+
+	hits       uint64
+	misses     uint64
+	collisions uint64
+}
+
+func New(config Config) *Table {
+	n := config.Shards
+	if n <= 0 {
+		n = DefaultShards
+	}
+	shards := 1
+	for shards < n {
+		shards <<= 1
+	}
+	capacity := config.Capacity
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	size := capacity / shards
+	if size < headerSize {
+		size = headerSize
+	}
+	if size > offsetMask {
+		size = offsetMask
+	}
+	t := &Table{
+		shards: make([]*shard, shards),
+		mask:   uint64(shards - 1),
+	}
+	for i := range t.shards {
+		t.shards[i] = &shard{
+			index: make(map[uint64]uint64),
+			arena: make([]byte, size),
+			gen:   1,
+		}
+	}
+	return t
+}
+
+//fnv-1a，避免把string转换为[]byte产生分配
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (t *Table) shard(hash uint64) *shard {
+	return t.shards[hash&t.mask]
+}
+
+//写入key，lifeSpan为0表示永久有效，value会被复制到arena中
+func (t *Table) Add(key string, value []byte, lifeSpan time.Duration) error {
+	if len(key) > maxKeySize {
+		return ErrEntryTooLarge
+	}
+	var expires int64
+	if lifeSpan > 0 {
+		expires = time.Now().Add(lifeSpan).UnixNano()
+	}
+	hash := hashKey(key)
+	return t.shard(hash).set(hash, key, value, expires)
+}
+
+//读取key对应value的副本，不存在或已过期时返回memory_cache.ErrNotFound
+func (t *Table) Value(key string) ([]byte, error) {
+	hash := hashKey(key)
+	value, collision, ok := t.shard(hash).get(hash, key, time.Now().UnixNano())
+	if collision {
+		atomic.AddUint64(&t.collisions, 1)
+	}
+	if !ok {
+		atomic.AddUint64(&t.misses, 1)
+		return nil, memory_cache.ErrNotFound
+	}
+	atomic.AddUint64(&t.hits, 1)
+	return value, nil
+}
+
+//删除key，arena中的空间在回绕时被复用
+func (t *Table) Delete(key string) error {
+	hash := hashKey(key)
+	if !t.shard(hash).del(hash, key) {
+		return memory_cache.ErrNotFound
+	}
+	return nil
+}
+
+func (t *Table) Exists(key string) bool {
+	hash := hashKey(key)
+	_, _, ok := t.shard(hash).get(hash, key, time.Now().UnixNano())
+	return ok
+}
+
+//索引中的条目数，可能包括已过期但还没被清理的条目
+func (t *Table) Count() int {
+	n := 0
+	for _, s := range t.shards {
+		s.mu.RLock()
+		n += len(s.index)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+func (t *Table) Flush() {
+	for _, s := range t.shards {
+		s.mu.Lock()
+		s.index = make(map[uint64]uint64)
+		s.offset = 0
+		s.gen++
+		s.mu.Unlock()
+	}
+}
+
+func (t *Table) Stats() Stats {
+	stats := Stats{
+		Entries:    t.Count(),
+		Hits:       atomic.LoadUint64(&t.hits),
+		Misses:     atomic.LoadUint64(&t.misses),
+		Collisions: atomic.LoadUint64(&t.collisions),
+	}
+	for _, s := range t.shards {
+		s.mu.RLock()
+		stats.Overwrites += s.wraps
+		s.mu.RUnlock()
+	}
+	return stats
+}
+
+//一个分片，arena按写入顺序追加，写满后gen加一并从头覆盖
+//索引中的位置为gen<<32|offset，只有当前gen中offset之前、或上一个gen中offset之后的条目还没被覆盖
+type shard struct {
+	mu     sync.RWMutex
+	index  map[uint64]uint64
+	arena  []byte
+	offset uint64 //下一个条目的写入位置
+	gen    uint64
+	wraps  uint64
+}
+
+func (s *shard) valid(loc uint64) bool {
+	gen, off := loc>>offsetBits, loc&offsetMask
+	return gen == s.gen && off < s.offset || gen+1 == s.gen && off >= s.offset
+}
+
+func (s *shard) set(hash uint64, key string, value []byte, expires int64) error {
+	size := uint64(headerSize + len(key) + len(value))
+	if size > uint64(len(s.arena)) {
+		return ErrEntryTooLarge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offset+size > uint64(len(s.arena)) {
+		s.wrap()
+	}
+	entry := s.arena[s.offset : s.offset+size]
+	binary.LittleEndian.PutUint64(entry, uint64(expires))
+	binary.LittleEndian.PutUint16(entry[8:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(entry[10:], uint32(len(value)))
+	copy(entry[headerSize:], key)
+	copy(entry[headerSize+len(key):], value)
+	s.index[hash] = s.gen<<offsetBits | s.offset
+	s.offset += size
+	return nil
+}
+
+//从头开始新的一轮写入，上上轮写入的条目已经全部被覆盖，顺便从索引中清理掉
+func (s *shard) wrap() {
+	s.gen++
+	s.offset = 0
+	s.wraps++
+	for hash, loc := range s.index {
+		if !s.valid(loc) {
+			delete(s.index, hash)
+		}
+	}
+}
+
+//读取条目，collision表示hash命中但key不同
+func (s *shard) get(hash uint64, key string, now int64) (value []byte, collision, ok bool) {
+	s.mu.RLock()
+	loc, found := s.index[hash]
+	if !found || !s.valid(loc) {
+		s.mu.RUnlock()
+		return nil, false, false
+	}
+	entry := s.arena[loc&offsetMask:]
+	expires := int64(binary.LittleEndian.Uint64(entry))
+	keyLen := int(binary.LittleEndian.Uint16(entry[8:]))
+	valueLen := int(binary.LittleEndian.Uint32(entry[10:]))
+	if string(entry[headerSize:headerSize+keyLen]) != key {
+		s.mu.RUnlock()
+		return nil, true, false
+	}
+	if expires != 0 && now >= expires {
+		s.mu.RUnlock()
+		s.delIf(hash, loc)
+		return nil, false, false
+	}
+	value = make([]byte, valueLen)
+	copy(value, entry[headerSize+keyLen:])
+	s.mu.RUnlock()
+	return value, false, true
+}
+
+func (s *shard) del(hash uint64, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, found := s.index[hash]
+	if !found || !s.valid(loc) {
+		return false
+	}
+	entry := s.arena[loc&offsetMask:]
+	keyLen := int(binary.LittleEndian.Uint16(entry[8:]))
+	if string(entry[headerSize:headerSize+keyLen]) != key {
+		return false
+	}
+	delete(s.index, hash)
+	return true
+}
+
+//只有索引仍指向loc时才删除，读锁升级为写锁期间条目可能已被重新写入
+func (s *shard) delIf(hash, loc uint64) {
+	s.mu.Lock()
+	if cur, ok := s.index[hash]; ok && cur == loc {
+		delete(s.index, hash)
+	}
+	s.mu.Unlock()
+}
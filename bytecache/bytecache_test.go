@@ -0,0 +1,207 @@
+package bytecache
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func TestAddValueDelete(t *testing.T) {
+	table := New(Config{Shards: 4, Capacity: 1 << 16})
+	if _, err := table.Value("k"); err != memory_cache.ErrNotFound {
+		t.Error("expected not found", err)
+	}
+	table.Add("k", []byte("v1"), 0)
+	table.Add("k", []byte("v2"), 0)
+	value, err := table.Value("k")
+	if err != nil || string(value) != "v2" {
+		t.Fatal("unexpected value", string(value), err)
+	}
+	value[0] = 'x' //返回的是副本
+	if value, _ := table.Value("k"); string(value) != "v2" {
+		t.Error("value should be copied out of the arena")
+	}
+	if table.Count() != 1 || !table.Exists("k") {
+		t.Error("unexpected count", table.Count())
+	}
+	if err := table.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete("k"); err != memory_cache.ErrNotFound {
+		t.Error("deleting twice should fail", err)
+	}
+	if table.Exists("k") {
+		t.Error("deleted key should not exist")
+	}
+
+	table.Add("empty", nil, 0)
+	if value, err := table.Value("empty"); err != nil || len(value) != 0 {
+		t.Error("empty value should be stored", err)
+	}
+	if err := table.Add("big", make([]byte, 1<<16), 0); err != ErrEntryTooLarge {
+		t.Error("entry larger than a shard should be rejected", err)
+	}
+
+	table.Flush()
+	if table.Count() != 0 || table.Exists("empty") {
+		t.Error("flush should drop everything")
+	}
+	if s := table.Stats(); s.Hits != 3 || s.Misses != 1 {
+		t.Error("unexpected stats", s)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	table := New(Config{Shards: 1, Capacity: 1 << 12})
+	table.Add("short", []byte("v"), 20*time.Millisecond)
+	table.Add("forever", []byte("v"), 0)
+	if !table.Exists("short") {
+		t.Fatal("item should exist before expiring")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := table.Value("short"); err != memory_cache.ErrNotFound {
+		t.Error("expired item should not be returned", err)
+	}
+	if table.Count() != 1 || !table.Exists("forever") {
+		t.Error("expired item should be removed from index", table.Count())
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	//每个分片只能放下约100个条目
+	table := New(Config{Shards: 1, Capacity: 100 * (headerSize + 8 + 8)})
+	value := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		if err := table.Add(key, value, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//最近写入的条目都在，最早写入的已被覆盖
+	for i := 950; i < 1000; i++ {
+		if !table.Exists(fmt.Sprintf("key%05d", i)) {
+			t.Fatal("recent key was evicted", i)
+		}
+	}
+	for i := 0; i < 800; i++ {
+		if table.Exists(fmt.Sprintf("key%05d", i)) {
+			t.Fatal("old key should be overwritten", i)
+		}
+	}
+	s := table.Stats()
+	if s.Overwrites < 9 || s.Entries > 200 {
+		t.Error("index should be cleaned on wrap", s)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	table := New(Config{Shards: 16, Capacity: 1 << 20})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(g*10000 + i%100)
+				table.Add(key, []byte(key), 0)
+				if value, err := table.Value(key); err == nil && string(value) != key {
+					t.Error("value mismatch", key, string(value))
+					return
+				}
+				if i%7 == 0 {
+					table.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+const benchEntries = 200000
+
+func benchKey(i int) string {
+	return "key:" + strconv.Itoa(i)
+}
+
+var benchValue = make([]byte, 64)
+
+func BenchmarkAdd(b *testing.B) {
+	b.Run("CacheTable", func(b *testing.B) {
+		table := memory_cache.Cache("bytecache_bench_add")
+		defer table.Flush()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			table.Add(benchKey(i%benchEntries), benchValue, 0)
+		}
+	})
+	b.Run("bytecache", func(b *testing.B) {
+		table := New(Config{})
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			table.Add(benchKey(i%benchEntries), benchValue, 0)
+		}
+	})
+}
+
+func BenchmarkValue(b *testing.B) {
+	b.Run("CacheTable", func(b *testing.B) {
+		table := memory_cache.Cache("bytecache_bench_value")
+		defer table.Flush()
+		for i := 0; i < benchEntries; i++ {
+			table.Add(benchKey(i), benchValue, 0)
+		}
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			table.Value(benchKey(i % benchEntries))
+		}
+	})
+	b.Run("bytecache", func(b *testing.B) {
+		table := New(Config{})
+		for i := 0; i < benchEntries; i++ {
+			table.Add(benchKey(i), benchValue, 0)
+		}
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			table.Value(benchKey(i % benchEntries))
+		}
+	})
+}
+
+//表中有benchEntries个条目时一次完整GC的耗时，heap-objects为堆上存活对象的数量
+func BenchmarkGC(b *testing.B) {
+	measure := func(b *testing.B, keep interface{}) {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(stats.HeapObjects), "heap-objects")
+		runtime.KeepAlive(keep)
+	}
+	b.Run("CacheTable", func(b *testing.B) {
+		table := memory_cache.Cache("bytecache_bench_gc")
+		defer table.Flush()
+		for i := 0; i < benchEntries; i++ {
+			table.Add(benchKey(i), append([]byte(nil), benchValue...), 0)
+		}
+		measure(b, table)
+	})
+	b.Run("bytecache", func(b *testing.B) {
+		table := New(Config{Capacity: benchEntries * 128})
+		for i := 0; i < benchEntries; i++ {
+			table.Add(benchKey(i), benchValue, 0)
+		}
+		measure(b, table)
+	})
+}
@@ -1,6 +1,9 @@
 package memory_cache
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 var (
 	cache = make(map[string]*CacheTable)//可以认为是数据库的database中存有多张表
@@ -8,6 +11,15 @@ var (
 )
 
 func Cache(name string)*CacheTable  {
+	return cacheWith(name, nil)
+}
+
+//与Cache相同，但表不存在时新建的表用policy限制容量；表已存在时直接返回，不会修改它的淘汰策略
+func CacheWithPolicy(name string, policy EvictionPolicy) *CacheTable {
+	return cacheWith(name, policy)
+}
+
+func cacheWith(name string, policy EvictionPolicy) *CacheTable {
 	mutex.RLock()
 	cacheTable,ok:=cache[name]
 	mutex.RUnlock()
@@ -21,9 +33,33 @@ func Cache(name string)*CacheTable  {
 				name:              name,
 				items:             make(map[interface{}]*CacheItem),
 			}
+			if policy != nil {
+				cacheTable.policy = policy
+				cacheTable.accesses = make(chan interface{}, accessBufferSize)
+			}
 			cache[name] = cacheTable
 		}
 		mutex.Unlock()
 	}
 	return cacheTable
-}
\ No newline at end of file
+}
+
+//查找已经存在的表，与Cache不同，表不存在时不会新建
+func LookupCache(name string) (*CacheTable, bool) {
+	mutex.RLock()
+	cacheTable, ok := cache[name]
+	mutex.RUnlock()
+	return cacheTable, ok
+}
+
+//按名字排序返回所有表名
+func Tables() []string {
+	mutex.RLock()
+	names := make([]string, 0, len(cache))
+	for name := range cache {
+		names = append(names, name)
+	}
+	mutex.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -2,8 +2,15 @@ package memory_cache
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -15,6 +22,15 @@ var (
 	v = "TestValue"
 )
 
+//表是全局注册的，go test -count多次运行时会拿到上一次留下的数据和设置
+//从注册表中移除同名的旧表，返回一张全新的表
+func newTestCache(name string) *CacheTable {
+	mutex.Lock()
+	delete(cache, name)
+	mutex.Unlock()
+	return Cache(name)
+}
+
 func TestCache(t *testing.T) {
 	table := Cache("TestCache")
 	table.Add(k+"_1", v, 0)
@@ -93,7 +109,7 @@ func TestNotFoundAdd(t *testing.T) {
 }
 
 func TestNotFoundAddConcurrency(t *testing.T) {
-	table := Cache("TestNotFoundAddConcurrency")
+	table := newTestCache("TestNotFoundAddConcurrency")
 	var finished sync.WaitGroup
 	var added int32
 	var idle int32
@@ -114,6 +130,9 @@ func TestNotFoundAddConcurrency(t *testing.T) {
 	}
 	finished.Wait()
 	t.Log(added, idle)
+	if added != 100 || idle != 900 {
+		t.Error("Error NotFoundAdd should add each key exactly once", added, idle)
+	}
 	//table.Foreach(func(key interface{}, item *CacheItem) {
 	//	v,_:=item.Data().(int)
 	//	k,_:=key.(int)
@@ -240,6 +259,12 @@ func TestAccessCount(t *testing.T) {
 	if len(ma)!=count -1{
 		t.Error("MostAccessed returns incorrect amount of items")
 	}
+	//count<=0时与原来一样返回空切片，而不是整张表
+	for _, n := range []int{0, -1} {
+		if ma = table.MostAccessed(n); ma == nil || len(ma) != 0 {
+			t.Error("MostAccessed with non-positive count should return an empty slice", n, len(ma))
+		}
+	}
 }
 
 func TestCallbacks(t *testing.T)  {
@@ -404,3 +429,1344 @@ func TestLogger(t *testing.T){
 	}
 	//fmt.Print(out)
 }
+
+func TestDefaultExpiration(t *testing.T) {
+	table := newTestCache("TestDefaultExpiration")
+	item := table.Add(k+"_1", v, DefaultExpiration)
+	if item.LifeSpan() != 0 {
+		t.Error("Error default life span should be 0 when not set")
+	}
+
+	table.SetDefaultLifeSpan(50 * time.Millisecond)
+	item = table.Add(k+"_2", v, DefaultExpiration)
+	if item.LifeSpan() != 50*time.Millisecond {
+		t.Error("Error get wrong default life span", item.LifeSpan())
+	}
+	if !table.NotFoundAdd(k+"_3", v, DefaultExpiration) {
+		t.Error("Error verifying NotFoundAdd with default life span")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if table.Exists(k+"_2") || table.Exists(k+"_3") {
+		t.Error("Error items with default life span should be expired")
+	}
+	if !table.Exists(k + "_1") {
+		t.Error("Error item added before SetDefaultLifeSpan should not expire")
+	}
+}
+
+func TestLifeSpanJitter(t *testing.T) {
+	table := Cache("TestLifeSpanJitter")
+	table.SetDefaultLifeSpan(10 * time.Second)
+	table.SetLifeSpanJitter(20)
+
+	lifeSpans := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		item := table.Add(i, v, DefaultExpiration)
+		if item.LifeSpan() < 8*time.Second || item.LifeSpan() > 12*time.Second {
+			t.Error("Error life span out of jitter bound", item.LifeSpan())
+		}
+		lifeSpans[item.LifeSpan()] = true
+	}
+	if len(lifeSpans) < 2 {
+		t.Error("Error life spans were not randomized")
+	}
+
+	item := table.Add(k, v, 0)
+	if item.LifeSpan() != 0 {
+		t.Error("Error jitter should not apply to items that never expire")
+	}
+}
+
+func TestKeyIndex(t *testing.T) {
+	table := newTestCache("TestKeyIndex")
+	if _, err := table.Scan("user:"); err != ErrKeyIndexDisabled {
+		t.Error("Error scan should fail before key index is enabled", err)
+	}
+	table.Add("user:42:name", v, 0)
+	table.Add(42, v, 0) //非string类型的key不进入索引
+	table.EnableKeyIndex()
+	table.Add("user:42:age", v, 0)
+	table.Add("user:43:name", v, 0)
+	table.Add("user:42:mail", v, 50*time.Millisecond)
+	table.Add("order:1", v, 0)
+
+	keys, err := table.Scan("user:42:")
+	if err != nil || strings.Join(keys, ",") != "user:42:age,user:42:mail,user:42:name" {
+		t.Error("Error scanning keys by prefix", keys, err)
+	}
+	keys, _ = table.Range("order:", "user:43:")
+	if strings.Join(keys, ",") != "order:1,user:42:age,user:42:mail,user:42:name" {
+		t.Error("Error ranging keys", keys)
+	}
+	keys, _ = table.Range("user:43:", "")
+	if strings.Join(keys, ",") != "user:43:name" {
+		t.Error("Error ranging keys without upper bound", keys)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	keys, _ = table.Scan("user:42:")
+	if strings.Join(keys, ",") != "user:42:age,user:42:name" {
+		t.Error("Error expired key should be removed from index", keys)
+	}
+
+	deleted, err := table.DeletePrefix("user:")
+	if err != nil || deleted != 3 || table.Exists("user:43:name") {
+		t.Error("Error deleting keys by prefix", deleted, err)
+	}
+	if !table.Exists(42) || !table.Exists("order:1") {
+		t.Error("Error DeletePrefix removed unrelated keys")
+	}
+
+	table.Flush()
+	keys, _ = table.Range("", "")
+	if len(keys) != 0 {
+		t.Error("Error key index should be empty after flush", keys)
+	}
+}
+
+func TestKeyIndexOrder(t *testing.T) {
+	table := Cache("TestKeyIndexOrder")
+	table.EnableKeyIndex()
+	for _, i := range rand.Perm(1000) {
+		table.Add(fmt.Sprintf("%04d", i), v, 0)
+	}
+	for i := 0; i < 1000; i += 2 {
+		table.Delete(fmt.Sprintf("%04d", i))
+	}
+	keys, _ := table.Range("", "")
+	if len(keys) != 500 || !sort.StringsAreSorted(keys) {
+		t.Error("Error keys from index are not sorted", len(keys))
+	}
+}
+
+func TestTags(t *testing.T) {
+	table := Cache("TestTags")
+	var m sync.Mutex
+	removed := 0
+	table.SetAboutToDeleteItem(func(item *CacheItem) {
+		m.Lock()
+		removed++
+		m.Unlock()
+	})
+
+	table.AddWithTags("fragment:1", v, 0, "user:1", "post:1")
+	table.AddWithTags("fragment:2", v, 0, "user:1")
+	table.AddWithTags("fragment:3", v, 50*time.Millisecond, "post:1")
+	table.AddWithTags("fragment:4", v, 0, "post:2")
+
+	if len(table.KeysByTag("post:1")) != 2 || len(table.KeysByTag("user:1")) != 2 {
+		t.Error("Error getting keys by tag")
+	}
+	if len(table.KeysByTag("unknown")) != 0 {
+		t.Error("Error unknown tag should have no keys")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if keys := table.KeysByTag("post:1"); len(keys) != 1 || keys[0] != "fragment:1" {
+		t.Error("Error expired item should be removed from tag index", keys)
+	}
+
+	//覆盖添加时使用新item的标签
+	table.AddWithTags("fragment:2", v, 0, "post:2")
+	if len(table.KeysByTag("user:1")) != 1 || len(table.KeysByTag("post:2")) != 2 {
+		t.Error("Error tags should follow the overwritten item")
+	}
+
+	m.Lock()
+	removed = 0
+	m.Unlock()
+	if table.InvalidateTag("post:2") != 2 || table.Exists("fragment:2") || table.Exists("fragment:4") {
+		t.Error("Error invalidating tag")
+	}
+	m.Lock()
+	if removed != 2 {
+		t.Error("Error InvalidateTag should fire delete callbacks", removed)
+	}
+	m.Unlock()
+
+	table.Delete("fragment:1")
+	if len(table.KeysByTag("user:1")) != 0 || len(table.KeysByTag("post:1")) != 0 {
+		t.Error("Error deleted item should be removed from tag index")
+	}
+
+	table.AddWithTags("fragment:5", v, 0, "user:2")
+	table.Flush()
+	if len(table.KeysByTag("user:2")) != 0 {
+		t.Error("Error tag index should be empty after flush")
+	}
+
+	//修改传入的切片不影响item的标签
+	tags := []string{"user:3"}
+	table.AddWithTags("fragment:6", v, 0, tags...)
+	tags[0] = "user:4"
+	if keys := table.KeysByTag("user:3"); len(keys) != 1 || len(table.KeysByTag("user:4")) != 0 {
+		t.Error("Error tags should be copied", keys)
+	}
+}
+
+func TestInvalidateTagOverwritten(t *testing.T) {
+	table := Cache("TestInvalidateTagOverwritten")
+	table.Flush()
+	table.AddWithTags("a", v, 0, "t")
+	table.AddWithTags("b", v, 0, "t")
+	//删除第一个key时把另一个key覆盖为不带标签的item，它不应该再被删除
+	var once sync.Once
+	table.SetAboutToDeleteItem(func(item *CacheItem) {
+		once.Do(func() {
+			other := "a"
+			if item.Key() == "a" {
+				other = "b"
+			}
+			table.Add(other, v, 0)
+		})
+	})
+	defer table.RemoveAboutToDeleteItem()
+	if n := table.InvalidateTag("t"); n != 1 {
+		t.Error("Error only the tagged item should be invalidated", n)
+	}
+	if table.Count() != 1 {
+		t.Error("Error overwritten untagged item should survive", table.Count())
+	}
+}
+
+func TestIterator(t *testing.T) {
+	table := Cache("TestIterator")
+	for i := 0; i < 10; i++ {
+		table.Add(i, v, 0)
+	}
+	table.Add(k, v, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond) //过期但可能还未被清理的item也不应该被迭代到
+
+	it := table.Iterator()
+	if !it.Next() {
+		t.Fatal("Error iterator should have items")
+	}
+	if it.Key() == k {
+		t.Error("Error iterator should skip expired items")
+	}
+	if it.Item().Key() != it.Key() {
+		t.Error("Error iterator returns mismatched key and item")
+	}
+	table.Add(k+"_new", v, 0) //迭代时写入不会死锁，快照之后加入的item不会被迭代到
+	first := it.Key()
+	for i := 0; i < 10; i++ {
+		if i != first && i%2 == 0 {
+			table.Delete(i) //删除还未迭代到的item
+		}
+	}
+	table.Delete(first) //删除当前item
+
+	seen := 1
+	for it.Next() {
+		key, ok := it.Key().(int)
+		if !ok || key%2 == 0 {
+			t.Error("Error iterator returns deleted or new item", it.Key())
+		}
+		seen++
+	}
+	if first.(int)%2 == 0 && seen != 6 || first.(int)%2 == 1 && seen != 5 {
+		t.Error("Error iterating remaining items", first, seen)
+	}
+	if it.Next() || it.Item() != nil {
+		t.Error("Error exhausted iterator should stay exhausted")
+	}
+}
+
+func TestForeachWithoutLock(t *testing.T) {
+	table := Cache("TestForeachWithoutLock")
+	for i := 0; i < 10; i++ {
+		table.Add(i, v, 0)
+	}
+	count := 0
+	table.Foreach(func(key interface{}, item *CacheItem) {
+		count++
+		table.Value(key)
+		table.Delete(key)
+	})
+	if count != 10 || table.Count() != 0 {
+		t.Error("Error iterating table with Foreach", count, table.Count())
+	}
+}
+
+func TestSelect(t *testing.T) {
+	table := Cache("TestSelect")
+	for i := 0; i < 20; i++ {
+		table.Add(i, i, time.Duration(20-i)*time.Second)
+		time.Sleep(time.Millisecond) //保证创建时间各不相同
+	}
+	table.Add(k, v, 0)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < i; j++ {
+			table.Value(i)
+		}
+	}
+
+	even := func(item *CacheItem) bool {
+		n, ok := item.Data().(int)
+		return ok && n%2 == 0
+	}
+	items := table.Select(even, ByMostAccessed, 3)
+	if len(items) != 3 || items[0].Key() != 18 || items[1].Key() != 16 || items[2].Key() != 14 {
+		t.Error("Error selecting with predicate and limit")
+	}
+	if items = table.Select(even, nil, 0); len(items) != 10 {
+		t.Error("Error selecting without order and limit", len(items))
+	}
+	if items = table.Select(nil, ByAccessedCount, 0); len(items) != 21 || items[20].Key() != 19 {
+		t.Error("Error selecting all items in order")
+	}
+
+	items = table.LeastAccessed(2)
+	if len(items) != 2 || items[1].AccessedCount() != 0 && items[1].AccessedCount() != 1 {
+		t.Error("Error getting least accessed items")
+	}
+	if items = table.OldestCreated(2); items[0].Key() != 0 || items[1].Key() != 1 {
+		t.Error("Error getting oldest created items")
+	}
+	table.Value(0)
+	if items = table.LeastRecentlyAccessed(2); items[0].Key() != k || items[1].Key() != 1 {
+		t.Error("Error getting least recently accessed items")
+	}
+	items = table.ExpiringSoon(100)
+	if len(items) != 20 || items[0].Key() != 19 || items[19].Key() != 0 {
+		t.Error("Error getting expiring soon items")
+	}
+	if table.ExpiringSoon(0)[0].ExpiresAt().IsZero() || !table.Select(func(item *CacheItem) bool {
+		return item.Key() == k
+	}, nil, 1)[0].ExpiresAt().IsZero() {
+		t.Error("Error getting expiration time")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	table := Cache("TestCompareAndSwap")
+	old := table.Add(k, 0, 0)
+	item, ok := table.CompareAndSwap(old, 1, time.Second)
+	if !ok || item.Data().(int) != 1 || item.LifeSpan() != time.Second {
+		t.Error("Error swapping current item")
+	}
+	if _, ok = table.CompareAndSwap(old, 2, 0); ok {
+		t.Error("Error swapping stale item should fail")
+	}
+	if err := table.CompareAndDelete(old); err != ErrNotFound || !table.Exists(k) {
+		t.Error("Error deleting stale item should fail", err)
+	}
+
+	var finished sync.WaitGroup
+	finished.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer finished.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					cur, _ := table.Peek(k)
+					if _, ok := table.CompareAndSwap(cur, cur.Data().(int)+1, 0); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	finished.Wait()
+	if item, _ = table.Peek(k); item.Data().(int) != 1001 {
+		t.Error("Error concurrent CompareAndSwap lost updates", item.Data())
+	}
+}
+
+func TestPeekAndTouch(t *testing.T) {
+	table := Cache("TestPeekAndTouch")
+	if _, err := table.Peek(k); err != ErrNotFound {
+		t.Error("Error peeking missing key", err)
+	}
+	if _, err := table.Touch(k, time.Second); err != ErrNotFound {
+		t.Error("Error touching missing key", err)
+	}
+
+	table.Add(k, v, 0)
+	item, err := table.Peek(k)
+	if err != nil || item.AccessedCount() != 0 {
+		t.Error("Error Peek should not update access info", err)
+	}
+	if item, err = table.Touch(k, 50*time.Millisecond); err != nil || item.LifeSpan() != 50*time.Millisecond {
+		t.Error("Error touching item", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if table.Exists(k) {
+		t.Error("Error touched item should expire with new life span")
+	}
+}
+
+func TestRegistryAndStats(t *testing.T) {
+	if _, ok := LookupCache("TestRegistryAndStats_missing"); ok {
+		t.Error("Error LookupCache should not find a table before it is created")
+	}
+	table := newTestCache("TestRegistryAndStats")
+	if found, ok := LookupCache("TestRegistryAndStats"); !ok || found != table {
+		t.Error("Error looking up existing table")
+	}
+	names := Tables()
+	if !sort.StringsAreSorted(names) || sort.SearchStrings(names, "TestRegistryAndStats") == len(names) {
+		t.Error("Error listing tables", names)
+	}
+
+	table.Add(k, v, 0)
+	table.Value(k)
+	table.Value(k)
+	table.Value(k + "_missing")
+	stats := table.Stats()
+	if stats.Name != "TestRegistryAndStats" || stats.Count != 1 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Error("Error getting table stats", stats)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	table := Cache("TestWatch")
+	events := make(chan TableEvent, 10)
+	cancel := table.Watch(func(event TableEvent) {
+		events <- event
+	})
+
+	table.Add(k, v, 0)
+	table.Add(k+"_1", v, 20*time.Millisecond)
+	table.Delete(k)
+	table.Delete(k) //删除不存在的key不会产生事件
+	time.Sleep(50 * time.Millisecond)
+	table.Flush()
+
+	expected := []struct {
+		t   EventType
+		key interface{}
+	}{{EventAdded, k}, {EventAdded, k + "_1"}, {EventDeleted, k}, {EventExpired, k + "_1"}, {EventFlushed, nil}}
+	var last uint64
+	for _, e := range expected {
+		select {
+		case event := <-events:
+			if event.Type != e.t || event.Key != e.key || event.Seq <= last {
+				t.Error("Error receiving event", event.Type, event.Key, event.Seq)
+			}
+			last = event.Seq
+		case <-time.After(time.Second):
+			t.Fatal("Error waiting for event", e.t)
+		}
+	}
+	if table.EventSeq() != last {
+		t.Error("Error getting event seq", table.EventSeq(), last)
+	}
+
+	cancel()
+	table.Add(k, v, 0)
+	time.Sleep(10 * time.Millisecond)
+	if len(events) != 0 {
+		t.Error("Error canceled watcher should not receive events")
+	}
+}
+
+type recordingWriter struct {
+	sync.Mutex
+	batches [][]WriteOp
+	fail    int //接下来失败的次数
+}
+
+func (w *recordingWriter) Write(ops []WriteOp) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.fail > 0 {
+		w.fail--
+		return fmt.Errorf("write failed")
+	}
+	w.batches = append(w.batches, append([]WriteOp(nil), ops...))
+	return nil
+}
+
+func (w *recordingWriter) ops() []WriteOp {
+	w.Lock()
+	defer w.Unlock()
+	var ops []WriteOp
+	for _, batch := range w.batches {
+		ops = append(ops, batch...)
+	}
+	return ops
+}
+
+func TestWriteThrough(t *testing.T) {
+	table := Cache("TestWriteThrough")
+	table.Flush()
+	w := &recordingWriter{}
+	table.SetWriteThrough(w)
+	defer table.SetWriteThrough(nil)
+
+	table.Add(k, v, 0)
+	table.Add(k, v+"_1", 0)
+	table.Delete(k)
+	table.Add(k+"_1", v, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond) //过期不写入
+	ops := w.ops()
+	if len(ops) != 4 || ops[0].Type != WriteAdd || ops[1].Type != WriteUpdate || ops[1].Data != v+"_1" ||
+		ops[2].Type != WriteDelete || ops[2].Key != k || ops[3].Type != WriteAdd {
+		t.Error("Error recording write-through ops", ops)
+	}
+
+	w.fail = 1
+	if item, err := table.Put(k, v, 0); err == nil || item != nil || table.Exists(k) {
+		t.Error("Error failed write-through should not modify table")
+	}
+	table.Add(k, v, 0)
+	w.fail = 1
+	if _, err := table.Delete(k); err == nil || !table.Exists(k) {
+		t.Error("Error failed write-through should not delete item")
+	}
+	w.fail = 1
+	if table.NotFoundAdd(k+"_2", v, 0) || table.Exists(k+"_2") {
+		t.Error("Error failed write-through should fail NotFoundAdd")
+	}
+	w.fail = 1
+	if added, err := table.NotFoundPut(k+"_2", v, 0); added || err == nil {
+		t.Error("Error NotFoundPut should return the write-through error")
+	}
+	old, _ := table.Peek(k)
+	w.fail = 1
+	if _, swapped, err := table.CompareAndPut(old, v+"_2", 0); swapped || err == nil {
+		t.Error("Error CompareAndPut should return the write-through error")
+	}
+	if _, swapped, err := table.CompareAndPut(old, v+"_2", 0); !swapped || err != nil {
+		t.Error("Error CompareAndPut should swap after write-through succeeds", err)
+	}
+
+	//后端删除失败时不执行删除回调
+	notified := false
+	table.SetAboutToDeleteItem(func(item *CacheItem) { notified = true })
+	defer table.RemoveAboutToDeleteItem()
+	w.fail = 1
+	if _, err := table.Delete(k); err == nil || notified {
+		t.Error("Error callbacks should not run when write-through delete fails")
+	}
+	if _, err := table.Delete(k); err != nil || !notified {
+		t.Error("Error callbacks should run after write-through delete succeeds", err)
+	}
+}
+
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(ops []WriteOp) error {
+	w.started <- struct{}{}
+	<-w.release
+	return nil
+}
+
+func TestWriteThroughOutsideLock(t *testing.T) {
+	table := Cache("TestWriteThroughOutsideLock")
+	table.Flush()
+	table.Add("other", v, 0)
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	table.SetWriteThrough(w)
+	defer table.SetWriteThrough(nil)
+
+	done := make(chan struct{})
+	go func() {
+		table.Add(k, v, 0)
+		close(done)
+	}()
+	<-w.started
+	//后端写入阻塞时table的读取不受影响
+	if _, err := table.Value("other"); err != nil || table.Count() != 1 {
+		t.Error("Error reads should not wait for the backend", err)
+	}
+	close(w.release)
+	<-done
+	if !table.Exists(k) {
+		t.Error("Error item should be added after backend write")
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	table := Cache("TestWriteBehind")
+	table.Flush()
+	w := &recordingWriter{}
+	var failed []WriteOp
+	table.SetWriteBehind(w, WriteBehindOptions{
+		MaxDelay:     time.Hour,
+		BatchSize:    3,
+		RetryBackoff: time.Millisecond,
+		MaxRetries:   1,
+		OnError:      func(ops []WriteOp, err error) { failed = append(failed, ops...) },
+	})
+
+	//同一个key的多次修改合并为一次，新增后的覆盖仍然是新增
+	table.Add(k, 1, 0)
+	table.Add(k, 2, 0)
+	table.Add(k+"_1", 1, 0)
+	if len(w.ops()) != 0 {
+		t.Fatal("Error write-behind should not write synchronously")
+	}
+	if err := table.FlushWrites(); err != nil {
+		t.Fatal(err)
+	}
+	ops := w.ops()
+	if len(ops) != 2 || ops[0].Type != WriteAdd || ops[0].Data != 2 || ops[1].Key != k+"_1" {
+		t.Error("Error coalescing write-behind ops", ops)
+	}
+
+	//删除后再添加对后端是覆盖，新增后删除再添加仍是新增
+	table.Delete(k)
+	table.Add(k, 5, 0)
+	table.Add(k+"_new", 1, 0)
+	table.Delete(k + "_new")
+	table.Add(k+"_new", 2, 0)
+	if err := table.FlushWrites(); err != nil {
+		t.Fatal(err)
+	}
+	ops = w.ops()[2:]
+	if len(ops) != 2 || ops[0].Type != WriteUpdate || ops[0].Data != 5 || ops[1].Type != WriteAdd || ops[1].Data != 2 {
+		t.Error("Error coalescing delete followed by add", ops)
+	}
+	w.Lock()
+	w.batches = w.batches[:1]
+	w.Unlock()
+
+	//队列达到BatchSize时立即写入
+	for i := 0; i < 3; i++ {
+		table.Add(fmt.Sprint(k, "_batch", i), i, 0)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(w.ops()) != 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("Error full batch should be written immediately", len(w.ops()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	//失败后重试，重试成功不调用OnError
+	w.Lock()
+	w.fail = 1
+	w.Unlock()
+	table.Delete(k)
+	if err := table.FlushWrites(); err != nil || len(failed) != 0 {
+		t.Error("Error retrying write-behind", err, failed)
+	}
+	w.Lock()
+	w.fail = 2
+	w.Unlock()
+	table.Delete(k + "_1")
+	if err := table.FlushWrites(); err == nil || len(failed) != 1 || failed[0].Type != WriteDelete {
+		t.Error("Error reporting write-behind failure", err, failed)
+	}
+
+	//关闭时写入剩余的修改
+	table.Add(k, 3, 0)
+	if err := table.CloseWriter(); err != nil {
+		t.Fatal(err)
+	}
+	ops = w.ops()
+	if last := ops[len(ops)-1]; last.Key != k || last.Data != 3 {
+		t.Error("Error flushing on close", last)
+	}
+	table.Add(k, 4, 0)
+	if len(w.ops()) != len(ops) {
+		t.Error("Error closed writer should not receive ops")
+	}
+}
+
+//同一个key的并发读取，用-cpu=1,2,4,8观察随核数的扩展性
+func BenchmarkValueHotKeyParallel(b *testing.B) {
+	table := Cache("BenchmarkValueHotKeyParallel")
+	table.Add(k, v, 0)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			table.Value(k)
+		}
+	})
+}
+
+func BenchmarkValueParallel(b *testing.B) {
+	table := Cache("BenchmarkValueParallel")
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = k + strconv.Itoa(i)
+		table.Add(keys[i], v, 0)
+	}
+	var seed int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddInt64(&seed, 1)) * 7919
+		for pb.Next() {
+			table.Value(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkKeepAliveParallel(b *testing.B) {
+	item := NewCacheItem(k, v, time.Minute)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			item.KeepAlive()
+		}
+	})
+}
+
+func TestLRUPolicy(t *testing.T) {
+	p := NewLRUPolicy(3)
+	for i := 0; i < 3; i++ {
+		if evicted := p.Add(i); len(evicted) != 0 {
+			t.Error("Error evicting before reaching capacity", evicted)
+		}
+	}
+	p.Access(0)
+	if evicted := p.Add(3); len(evicted) != 1 || evicted[0] != 1 {
+		t.Error("Error evicting least recently used key", evicted)
+	}
+	p.Remove(2)
+	if evicted := p.Add(4); len(evicted) != 0 {
+		t.Error("Error removed key should free capacity", evicted)
+	}
+}
+
+func TestBoundedTable(t *testing.T) {
+	table := newTestCache("TestBoundedTable")
+	table.SetEvictionPolicy(NewLRUPolicy(2))
+	defer table.SetEvictionPolicy(nil)
+	var deleted []interface{}
+	table.SetAboutToDeleteItem(func(item *CacheItem) {
+		deleted = append(deleted, item.Key())
+	})
+	defer table.RemoveAboutToDeleteItem()
+	events := make(chan TableEvent, 10)
+	cancel := table.Watch(func(event TableEvent) {
+		if event.Type == EventEvicted {
+			events <- event
+		}
+	})
+	defer cancel()
+
+	table.Add(k+"_0", v, 0)
+	table.Add(k+"_1", v, 0)
+	table.Value(k + "_0")
+	table.Add(k+"_1", v, 0) //覆盖不增加条目，视为一次访问
+	table.Add(k+"_2", v, 0)
+	if table.Count() != 2 || table.Exists(k+"_0") {
+		t.Error("Error evicting least recently used item", table.Count())
+	}
+	if len(deleted) != 1 || deleted[0] != k+"_0" || table.Stats().Evictions != 1 {
+		t.Error("Error running delete callbacks on eviction", deleted)
+	}
+	select {
+	case event := <-events:
+		if event.Key != k+"_0" {
+			t.Error("Error publishing eviction event", event.Key)
+		}
+	case <-time.After(time.Second):
+		t.Error("Error waiting for eviction event")
+	}
+
+	//删除后空出容量
+	table.Delete(k + "_1")
+	table.Add(k+"_3", v, 0)
+	if table.Count() != 2 || table.Stats().Evictions != 1 {
+		t.Error("Error deleted key should free capacity", table.Count())
+	}
+
+	table.Flush()
+	for i := 0; i < 2; i++ {
+		table.Add(k+strconv.Itoa(i), v, 0)
+	}
+	if table.Stats().Evictions != 1 {
+		t.Error("Error flush should reset the policy")
+	}
+
+	//设置更小的容量时立即淘汰多出的item
+	table.SetEvictionPolicy(NewLRUPolicy(1))
+	if table.Count() != 1 {
+		t.Error("Error applying policy to existing items", table.Count())
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	p := NewTinyLFUPolicy(100)
+	for i := 0; i < 100; i++ {
+		p.Add(i)
+	}
+	for n := 0; n < 5; n++ {
+		for i := 0; i < 100; i++ {
+			p.Access(i)
+		}
+	}
+	//只出现一次的新key基本不能挤掉经常访问的key，sketch的hash冲突可能导致个别误判
+	displaced := 0
+	for i := 1000; i < 1100; i++ {
+		for _, key := range p.Add(i) {
+			if key.(int) < 100 {
+				displaced++
+			}
+		}
+	}
+	if displaced > 5 {
+		t.Error("Error one-hit keys displaced frequent keys", displaced)
+	}
+}
+
+//在Zipf分布的访问中穿插一次性的扫描，比较两种策略在容量受限table上的命中率
+func simulateHitRatio(name string, policy EvictionPolicy) float64 {
+	table := Cache(name)
+	table.SetEvictionPolicy(policy)
+	table.SetLoadData(func(key interface{}, args ...interface{}) *CacheItem {
+		return NewCacheItem(key, v, 0)
+	})
+	defer table.SetLoadData(nil)
+
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.01, 1, 99999)
+	scan := 1000000
+	for i := 0; i < 100000; i++ {
+		table.Value(int(zipf.Uint64()))
+		if i%1000 == 999 {
+			for j := 0; j < 200; j++ {
+				table.Value(scan)
+				scan++
+			}
+		}
+	}
+	stats := table.Stats()
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+func TestTinyLFUHitRatio(t *testing.T) {
+	lru := simulateHitRatio("TestTinyLFUHitRatio_lru", NewLRUPolicy(1000))
+	tinyLFU := simulateHitRatio("TestTinyLFUHitRatio_tinylfu", NewTinyLFUPolicy(1000))
+	t.Logf("hit ratio: lru %.3f, w-tinylfu %.3f", lru, tinyLFU)
+	if tinyLFU <= lru {
+		t.Error("Error W-TinyLFU should beat LRU on scan-polluted Zipf trace", lru, tinyLFU)
+	}
+}
+
+func TestARCPolicy(t *testing.T) {
+	p := NewARCPolicy(4).(*arcPolicy)
+	for i := 0; i < 4; i++ {
+		p.Add(i)
+	}
+	p.Access(0)
+	p.Access(1) //0、1进入T2
+	if evicted := p.Add(4); len(evicted) != 1 || evicted[0] != 2 {
+		t.Fatal("Error evicting from T1", evicted)
+	}
+	//在B1中命中后增大T1的目标，并直接进入T2
+	if evicted := p.Add(2); len(evicted) != 1 || p.p != 1 {
+		t.Error("Error adapting to B1 hit", evicted, p.p)
+	}
+	if e := p.elems[2].Value.(*arcEntry); e.where != arcT2 {
+		t.Error("Error ghost hit should go to T2", e.where)
+	}
+	p.Remove(2)
+	if evicted := p.Add(5); len(evicted) != 0 {
+		t.Error("Error removed key should free capacity", evicted)
+	}
+	if p.resident() != 4 {
+		t.Error("Error counting resident keys", p.resident())
+	}
+}
+
+func Test2QPolicy(t *testing.T) {
+	p := New2QPolicy(8)
+	var evicted []interface{}
+	for i := 0; i < 12; i++ {
+		evicted = append(evicted, p.Add(i)...)
+	}
+	if len(evicted) != 4 || evicted[0] != 0 {
+		t.Fatal("Error evicting from A1in in FIFO order", evicted)
+	}
+	//0、1被淘汰后再次出现，从A1out进入Am，之后的扫描只会淘汰A1in中的key
+	p.Add(0)
+	p.Add(1)
+	for i := 100; i < 200; i++ {
+		for _, key := range p.Add(i) {
+			if key == 0 || key == 1 {
+				t.Fatal("Error scan evicted a hot key", key)
+			}
+		}
+	}
+}
+
+func TestCacheWithPolicy(t *testing.T) {
+	for _, name := range []string{"lru", "TinyLFU", "arc", "2q"} {
+		policy, err := NewEvictionPolicy(name, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := CacheWithPolicy("TestCacheWithPolicy_"+name, policy)
+		for i := 0; i < 100; i++ {
+			table.Add(i, v, 0)
+		}
+		if table.Count() > 10 {
+			t.Error("Error bounding table with policy", name, table.Count())
+		}
+		if CacheWithPolicy("TestCacheWithPolicy_"+name, nil) != table || Cache("TestCacheWithPolicy_"+name) != table {
+			t.Error("Error existing table should be returned")
+		}
+	}
+	if _, err := NewEvictionPolicy("mru", 10); err != ErrUnknownPolicy {
+		t.Error("Error unknown policy should be rejected", err)
+	}
+}
+
+func TestAdaptiveHitRatio(t *testing.T) {
+	lru := simulateHitRatio("TestAdaptiveHitRatio_lru", NewLRUPolicy(1000))
+	arc := simulateHitRatio("TestAdaptiveHitRatio_arc", NewARCPolicy(1000))
+	twoQ := simulateHitRatio("TestAdaptiveHitRatio_2q", New2QPolicy(1000))
+	t.Logf("hit ratio: lru %.3f, arc %.3f, 2q %.3f", lru, arc, twoQ)
+	if arc <= lru || twoQ <= lru {
+		t.Error("Error ARC and 2Q should beat LRU on scan-polluted Zipf trace", lru, arc, twoQ)
+	}
+}
+
+func TestAccessTrace(t *testing.T) {
+	table := Cache("TestAccessTrace")
+	table.Flush()
+	if _, err := table.TraceRecords(); err != ErrTraceDisabled {
+		t.Error("Error trace should be disabled by default", err)
+	}
+	table.EnableTrace(TraceOptions{Capacity: 4})
+	table.Add("a", []byte("hello"), 0)
+	table.Value("a")
+	table.Value("b")
+	table.Delete("a")
+	table.Delete("a")
+
+	//容量为4，最早的Add被覆盖
+	records, _ := table.TraceRecords()
+	if len(records) != 4 {
+		t.Fatal("Error ring buffer size", len(records))
+	}
+	want := []struct {
+		op   TraceOp
+		size int
+		hit  bool
+	}{{TraceValue, 5, true}, {TraceValue, 0, false}, {TraceDelete, 5, true}, {TraceDelete, 0, false}}
+	for i, w := range want {
+		r := records[i]
+		if r.Op != w.op || r.Size != w.size || r.Hit != w.hit {
+			t.Error("Error trace record", i, r)
+		}
+	}
+	if records[0].KeyHash != records[2].KeyHash || records[0].KeyHash == records[1].KeyHash {
+		t.Error("Error key hash", records)
+	}
+
+	var buf bytes.Buffer
+	if err := table.DumpTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[0] != "timestamp,key,op,size,hit" || !strings.HasSuffix(lines[3], ",delete,5,1") {
+		t.Error("Error dumped trace", lines)
+	}
+
+	//按key抽样，同一个key要么全部记录要么都不记录
+	table.EnableTrace(TraceOptions{SampleRate: 0.25})
+	for i := 0; i < 1000; i++ {
+		table.Add(i, v, 0)
+		table.Value(i)
+	}
+	records, _ = table.TraceRecords()
+	sampled := map[uint64]int{}
+	for _, r := range records {
+		sampled[r.KeyHash]++
+	}
+	if len(sampled) < 150 || len(sampled) > 350 {
+		t.Error("Error sample rate", len(sampled))
+	}
+	for h, n := range sampled {
+		if n != 2 {
+			t.Error("Error key sampled partially", h, n)
+		}
+	}
+
+	table.DisableTrace()
+	table.Value(1)
+	if _, err := table.TraceRecords(); err != ErrTraceDisabled {
+		t.Error("Error trace should be disabled", err)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	table := Cache("TestHotKeys")
+	if _, err := table.HotKeys(10); err != ErrHotKeysDisabled {
+		t.Error("Error hot keys should be disabled by default", err)
+	}
+	table.EnableHotKeys(HotKeyOptions{Capacity: 16})
+	for i := 0; i < 1000; i++ {
+		table.Value("hot")
+		table.Value(i) //大量只访问一次的key，在space-saving中互相替换
+		if i%2 == 0 {
+			table.Value("warm")
+		}
+	}
+	keys, err := table.HotKeys(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Key != "hot" || keys[1].Key != "warm" || keys[0].Rate <= keys[1].Rate {
+		t.Error("Error top hot keys", keys)
+	}
+	if keys[0].Error != 0 {
+		t.Error("Error hot key tracked from the start should be exact", keys[0])
+	}
+	table.DisableHotKeys()
+}
+
+func TestHotKeyDecay(t *testing.T) {
+	start := time.Now()
+	tracker := newHotKeyTracker(HotKeyOptions{Capacity: 8, HalfLife: time.Second}, start)
+	//上周的热点：很久之前访问了很多次
+	for i := 0; i < 10000; i++ {
+		tracker.record("old", start)
+	}
+	//当前的热点：最近10秒每秒100次
+	now := start.Add(time.Hour)
+	for i := 0; i < 1000; i++ {
+		now = now.Add(10 * time.Millisecond)
+		tracker.record("new", now)
+	}
+	keys := tracker.top(0, now)
+	if keys[0].Key != "new" || keys[1].Rate > 1e-6 {
+		t.Error("Error old key should have decayed", keys)
+	}
+	//稳定速率下衰减计数应接近真实速率
+	if math.Abs(keys[0].Rate-100) > 5 {
+		t.Error("Error rate estimate", keys[0].Rate)
+	}
+	//超过半衰期后速率减半
+	if rate := tracker.top(1, now.Add(time.Second))[0].Rate; math.Abs(rate-keys[0].Rate/2) > 1e-6 {
+		t.Error("Error rate after one half life", rate)
+	}
+}
+
+func TestHotKeyNonBlocking(t *testing.T) {
+	table := Cache("TestHotKeyNonBlocking")
+	table.EnableHotKeys(HotKeyOptions{})
+	defer table.DisableHotKeys()
+	table.RLock()
+	tracker := table.hotKeys
+	table.RUnlock()
+
+	//tracker的锁被占用时Value不等待，缓冲满后的访问被丢弃
+	tracker.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10*hotKeyBufferSize; i++ {
+			table.Value("k")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Error Value blocked on the hot key tracker")
+	}
+	tracker.mu.Unlock()
+	keys, _ := table.HotKeys(1)
+	if len(keys) != 1 || keys[0].Key != "k" {
+		t.Error("Error buffered accesses should be counted", keys)
+	}
+}
+
+func TestHotKeyThreshold(t *testing.T) {
+	var fired []interface{}
+	start := time.Now()
+	tracker := newHotKeyTracker(HotKeyOptions{
+		Capacity:  4,
+		HalfLife:  time.Second,
+		Threshold: 50,
+		OnHot: func(key interface{}, rate float64) {
+			if rate < 50 {
+				t.Error("Error rate below threshold", rate)
+			}
+			fired = append(fired, key)
+		},
+	}, start)
+	now := start
+	burst := func(key string, perSecond, seconds int) {
+		for i := 0; i < perSecond*seconds; i++ {
+			now = now.Add(time.Second / time.Duration(perSecond))
+			tracker.record(key, now)
+		}
+	}
+	burst("a", 10, 5)
+	burst("b", 200, 5)
+	burst("b", 200, 5) //一直保持热点时不重复触发
+	tracker.top(0, now) //处理缓冲中剩余的访问
+	if len(fired) != 1 || fired[0] != "b" {
+		t.Error("Error hot callback", fired)
+	}
+	now = now.Add(time.Minute)
+	burst("b", 10, 5) //冷却后再次升温会重新触发
+	burst("b", 200, 5)
+	tracker.top(0, now)
+	if len(fired) != 2 {
+		t.Error("Error hot callback after cooling down", fired)
+	}
+}
+
+func newTestRateLimiter(t *testing.T, name string, algorithm RateAlgorithm) (*RateLimiter, *time.Time) {
+	table := Cache(name)
+	table.Flush()
+	limiter, err := NewRateLimiter(table, RateLimiterOptions{Algorithm: algorithm, Limit: 10, Window: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestTokenBucket(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, "TestTokenBucket", TokenBucket)
+	for i := 0; i < 10; i++ {
+		if !limiter.Allow("a") {
+			t.Fatal("Error burst should be allowed", i)
+		}
+	}
+	if limiter.Allow("a") || !limiter.Allow("b") {
+		t.Error("Error bucket of a should be empty and independent of b")
+	}
+	//每100ms补充一个令牌
+	*now = now.Add(250 * time.Millisecond)
+	if !limiter.AllowN("a", 2) || limiter.Allow("a") {
+		t.Error("Error refill")
+	}
+	//预留时透支未来的令牌
+	r := limiter.Reserve("a", 3)
+	if !r.OK() || r.Delay() < 250*time.Millisecond || r.Delay() > 300*time.Millisecond {
+		t.Error("Error reservation delay", r.Delay())
+	}
+	r.Cancel()
+	if r = limiter.Reserve("a", 1); r.Delay() > 100*time.Millisecond {
+		t.Error("Error cancelled reservation should be refunded", r.Delay())
+	}
+	if limiter.Reserve("a", 11).OK() {
+		t.Error("Error reservation above limit should fail")
+	}
+	if _, err := NewRateLimiter(Cache("TestTokenBucket"), RateLimiterOptions{Limit: 0, Window: time.Second}); err != ErrInvalidRateLimit {
+		t.Error("Error invalid options", err)
+	}
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, "TestSlidingWindowLog", SlidingWindowLog)
+	start := *now
+	for i := 0; i < 10; i++ {
+		if !limiter.Allow("a") {
+			t.Fatal("Error requests within limit should be allowed", i)
+		}
+		*now = now.Add(50 * time.Millisecond)
+	}
+	//窗口内已有10次请求，最早的一次在start
+	if limiter.Allow("a") {
+		t.Error("Error limit exceeded")
+	}
+	r := limiter.Reserve("a", 2)
+	if want := start.Add(50 * time.Millisecond).Add(time.Second).Sub(*now); !r.OK() || r.Delay() != want {
+		t.Error("Error reservation delay", r.Delay(), want)
+	}
+	*now = start.Add(time.Second + time.Millisecond)
+	if limiter.Allow("a") {
+		t.Error("Error reserved slots should not be reused")
+	}
+	r.Cancel()
+	if !limiter.Allow("a") {
+		t.Error("Error cancelled reservation should free its slots")
+	}
+}
+
+func TestRateLimiterInvalidN(t *testing.T) {
+	for _, algorithm := range []RateAlgorithm{TokenBucket, SlidingWindowLog} {
+		limiter, _ := newTestRateLimiter(t, "TestRateLimiterInvalidN", algorithm)
+		if limiter.AllowN("a", -5) || limiter.Reserve("a", -1).OK() {
+			t.Error("Error negative n should be rejected", algorithm)
+		}
+		r := limiter.Reserve("a", 0)
+		if !limiter.AllowN("a", 0) || !r.OK() || r.Delay() != 0 {
+			t.Error("Error n == 0 should always be allowed", algorithm)
+		}
+		if limiter.table.Exists("a") {
+			t.Error("Error n <= 0 should not create state", algorithm)
+		}
+		//负数不能用来退还配额
+		for i := 0; i < 10; i++ {
+			limiter.Allow("b")
+		}
+		limiter.AllowN("b", -10)
+		if limiter.Allow("b") {
+			t.Error("Error negative n should not refill the quota", algorithm)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	table := Cache("TestRateLimiterWait")
+	table.Flush()
+	limiter, _ := NewRateLimiter(table, RateLimiterOptions{Limit: 1, Window: 50 * time.Millisecond})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Error("Error Wait should block until tokens refill", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "a"); err != ErrRateLimited {
+		t.Error("Error deadline before the reservation", err)
+	}
+	//空闲的key随item过期
+	time.Sleep(200 * time.Millisecond)
+	if table.Exists("a") {
+		t.Error("Error idle bucket should expire")
+	}
+}
+
+func TestLock(t *testing.T) {
+	table := Cache("TestLock")
+	table.Flush()
+	token, err := table.TryLockKey("job", "w1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.TryLockKey("job", "w2", time.Minute); err != ErrLocked {
+		t.Error("Error lock should be exclusive", err)
+	}
+	if lease, err := table.LockHolder("job"); err != nil || lease.Owner != "w1" || lease.Token != token {
+		t.Error("Error lock holder", lease, err)
+	}
+	if err := table.RenewLock("job", "w2", time.Minute); err != ErrNotLockOwner {
+		t.Error("Error only the owner can renew", err)
+	}
+	if err := table.UnlockKey("job", "w2"); err != ErrNotLockOwner {
+		t.Error("Error only the owner can unlock", err)
+	}
+	if err := table.UnlockKey("job", "w1"); err != nil {
+		t.Error("Error unlock", err)
+	}
+	token2, err := table.TryLockKey("job", "w2", time.Minute)
+	if err != nil || token2 <= token {
+		t.Error("Error fencing token should increase", token, token2, err)
+	}
+	if _, err := table.TryLockKey("job", "w3", 0); err != ErrInvalidLockTTL {
+		t.Error("Error zero ttl", err)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	table := Cache("TestLockExpiry")
+	table.Flush()
+	token, _ := table.TryLockKey("job", "w1", 50*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if err := table.RenewLock("job", "w1", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := table.TryLockKey("job", "w2", time.Minute); err != ErrLocked {
+		t.Error("Error renewed lease should still be held", err)
+	}
+	if lease, _ := table.LockHolder("job"); lease.Token != token {
+		t.Error("Error renew should keep the fencing token", lease)
+	}
+
+	//持有者失联，租约过期后其他人可以加锁，旧持有者不能再释放新锁
+	time.Sleep(100 * time.Millisecond)
+	if _, err := table.LockHolder("job"); err != ErrNotFound {
+		t.Error("Error expired lease should not be reported", err)
+	}
+	token2, err := table.TryLockKey("job", "w2", time.Minute)
+	if err != nil || token2 <= token {
+		t.Fatal("Error lock after expiry", token2, err)
+	}
+	if err := table.UnlockKey("job", "w1"); err != ErrNotLockOwner || !table.Exists("job") {
+		t.Error("Error stale owner should not unlock", err)
+	}
+	if err := table.RenewLock("job", "w1", time.Minute); err != ErrNotLockOwner {
+		t.Error("Error stale owner should not renew", err)
+	}
+}
+
+func TestLockConcurrent(t *testing.T) {
+	table := Cache("TestLockConcurrent")
+	table.Flush()
+	var wg sync.WaitGroup
+	var acquired int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			if _, err := table.TryLockKey("job", owner, time.Minute); err == nil {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Error("Error exactly one owner should acquire the lock", acquired)
+	}
+}
+
+func TestEventQueueBound(t *testing.T) {
+	table := Cache("TestEventQueueBound")
+	table.Flush()
+	block := make(chan struct{})
+	var received []uint64
+	var mu sync.Mutex
+	cancel := table.Watch(func(event TableEvent) {
+		<-block
+		mu.Lock()
+		received = append(received, event.Seq)
+		mu.Unlock()
+	})
+	dropped := table.DroppedEvents()
+	for i := 0; i < maxEventQueue+100; i++ {
+		table.Add(k, i, 0)
+	}
+	if table.DroppedEvents()-dropped < 99 {
+		t.Error("Error overflowing events should be dropped", table.DroppedEvents()-dropped)
+	}
+	close(block)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) > maxEventQueue+1 || received[len(received)-1] == table.EventSeq() {
+		t.Error("Error dropped events should not be delivered", len(received))
+	}
+}
+
+func TestEventDispatcherExit(t *testing.T) {
+	table := Cache("TestEventDispatcherExit")
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		got := make(chan struct{}, 1)
+		cancel := table.Watch(func(event TableEvent) {
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		})
+		table.Add(k, i, 0)
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("Error event not delivered after resubscribing", i)
+		}
+		cancel()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Error("Error dispatcher should exit after the last watcher unsubscribes")
+	}
+}
@@ -2,23 +2,28 @@ package memory_cache
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //cache key value 记录对象
 
 type CacheItem struct {
-	sync.RWMutex //共享锁来控制访问时间与次数并发修改
+	//访问信息只用原子操作读写，读取热点key时不需要加锁，放在结构体开头保证64位原子操作的对齐
+	lifeSpan      int64 //生命周期(过期时间)，time.Duration
+	accessedOn    int64 //被访问时间，UnixNano
+	accessedCount int64 //被访问的次数
+
+	sync.RWMutex //共享锁来控制回调的并发修改
 
 	key  interface{}
 	data interface{}
 
-	lifeSpan      time.Duration //生命周期(过期时间)
-	createdOn     time.Time     //被创建时间
-	accessedOn    time.Time     //被访问时间
-	accessedCount int64         //被访问的次数
+	createdOn time.Time //被创建时间
 
 	aboutToExpire []func(key interface{}) //记录被移除后的回调函数组
+
+	tags []string //分组标签，用于按标签批量失效
 }
 
 func NewCacheItem(key, data interface{}, lifeSpan time.Duration) *CacheItem {
@@ -27,9 +32,9 @@ func NewCacheItem(key, data interface{}, lifeSpan time.Duration) *CacheItem {
 		RWMutex:       sync.RWMutex{},
 		key:           key,
 		data:          data,
-		lifeSpan:      lifeSpan,
+		lifeSpan:      int64(lifeSpan),
 		createdOn:     t,
-		accessedOn:    t,
+		accessedOn:    t.UnixNano(),
 		accessedCount: 0,
 		aboutToExpire: nil,
 	}
@@ -46,8 +51,23 @@ func (item *CacheItem) Data() interface{} {
 	return item.data
 }
 
+func (item *CacheItem) Tags() []string {
+	return item.tags
+}
+
+//tags在item创建后不再修改，不需要加锁
+func (item *CacheItem) hasTag(tag string) bool {
+	for _, t := range item.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//lifeSpan可以被CacheTable.Touch修改
 func (item *CacheItem) LifeSpan() time.Duration {
-	return time.Duration(item.lifeSpan)
+	return time.Duration(atomic.LoadInt64(&item.lifeSpan))
 }
 
 func (item *CacheItem) CreatedOn() time.Time {
@@ -55,24 +75,44 @@ func (item *CacheItem) CreatedOn() time.Time {
 }
 
 func (item *CacheItem) AccessedOn() time.Time {
-	item.RLock()
-	defer item.RUnlock()
-	return item.accessedOn
+	return time.Unix(0, atomic.LoadInt64(&item.accessedOn))
 }
 
 func (item *CacheItem) AccessedCount() int64 {
-	item.RLock()
-	defer item.RUnlock()
-	return item.accessedCount
+	return atomic.LoadInt64(&item.accessedCount)
+}
+
+//预计的过期时间，每次被访问都会向后推迟，永久有效时返回零值
+func (item *CacheItem) ExpiresAt() time.Time {
+	lifeSpan := atomic.LoadInt64(&item.lifeSpan)
+	if lifeSpan <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, atomic.LoadInt64(&item.accessedOn)+lifeSpan)
+}
+
+//距离过期还剩多少时间，已过期时为负数，调用方需先确认lifeSpan>0
+func (item *CacheItem) remaining(now time.Time) time.Duration {
+	return time.Duration(atomic.LoadInt64(&item.lifeSpan) - (now.UnixNano() - atomic.LoadInt64(&item.accessedOn)))
+}
+
+//item在now时刻是否已经过期，lifeSpan为0表示永久有效
+func (item *CacheItem) expired(now time.Time) bool {
+	return item.LifeSpan() > 0 && item.remaining(now) < 0
 }
 
 //以下都是更新操作
 //保活的操作,每当Value读取后，都会重置删除定时。
+//只使用原子操作，同一个key的并发读取不会互相等待
 func (item *CacheItem) KeepAlive() {
-	item.Lock()
-	item.accessedOn = time.Now()
-	item.accessedCount++
-	item.Unlock()
+	atomic.StoreInt64(&item.accessedOn, time.Now().UnixNano())
+	atomic.AddInt64(&item.accessedCount, 1)
+}
+
+//修改生命周期并视为一次访问，供CacheTable.Touch使用
+func (item *CacheItem) touch(lifeSpan time.Duration) {
+	atomic.StoreInt64(&item.lifeSpan, int64(lifeSpan))
+	atomic.StoreInt64(&item.accessedOn, time.Now().UnixNano())
 }
 
 //更新回调操作
@@ -2,21 +2,46 @@ package memory_cache
 
 import (
 	"log"
-	"sort"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //CacheTable管理 CacheItem(key value 对象)
 
+//Add时传入DefaultExpiration表示使用table的默认生命周期
+const DefaultExpiration time.Duration = -1
+
 type CacheTable struct {
+	//命中统计，放在结构体开头保证64位原子操作的对齐
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	fencing   uint64 //TryLockKey分配的最大fencing token
+
 	sync.RWMutex
 
 	name            string
 	items           map[interface{}]*CacheItem
 	cleanupTimer    *time.Timer   //清空table缓存定时器
 	cleanupInterval time.Duration //清空间隔
+	defaultLifeSpan time.Duration //传入DefaultExpiration时使用的生命周期
+	lifeSpanJitter  float64       //生命周期随机抖动的百分比，避免同一时刻批量过期
 	logger          *log.Logger
+	events          eventBus //表事件的订阅与分发
+	//持久化，两者最多设置一个
+	writer      Writer                    //write-through，在keyLock内同步调用，不持有table的锁
+	writeBehind *writeBehind              //write-behind，异步合并写入
+	keyLocks    [keyLockShards]sync.Mutex //write-through时串行化同一个key的修改
+	//容量限制
+	policy   EvictionPolicy   //为nil时table不限制容量
+	accesses chan interface{} //Value命中的key先缓冲在这里，写入时再批量交给policy
+	tracer   *accessTracer    //为nil时不记录访问trace
+	hotKeys  *hotKeyTracker   //为nil时不统计热点key
+	//索引
+	keyIndex *keyIndex                           //可选的有序key索引，只索引string类型的key
+	tagIndex map[string]map[interface{}]struct{} //标签到key集合的索引
 	//回调函数
 	loadData          func(key interface{}, args ...interface{}) *CacheItem //当试图读一个不存在的记录时 触发回调
 	addedItem         []func(item *CacheItem)                               //添加一个新的item记录时 触发回调
@@ -68,6 +93,27 @@ func (table *CacheTable) RemoveAboutToDeleteItem() {
 	table.Unlock()
 }
 
+//设置默认生命周期，Add时传入DefaultExpiration即使用该值，0表示永久有效
+func (table *CacheTable) SetDefaultLifeSpan(lifeSpan time.Duration) {
+	table.Lock()
+	table.defaultLifeSpan = lifeSpan
+	table.Unlock()
+}
+
+//设置生命周期抖动百分比(0~100)，item实际生命周期在lifeSpan*(1±percent/100)之间随机
+//预热大量相同TTL的key时可以把过期时间打散，避免同时过期击穿后端
+func (table *CacheTable) SetLifeSpanJitter(percent float64) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	table.Lock()
+	table.lifeSpanJitter = percent
+	table.Unlock()
+}
+
 //设置Log
 func (table *CacheTable) SetLogger(logger *log.Logger) {
 	table.Lock()
@@ -84,30 +130,149 @@ func (table *CacheTable) Count() int {
 	return len(table.items)
 }
 
-//trans中尽量不要有阻塞操作。
+//table的统计信息
+type TableStats struct {
+	Name      string `json:"name"`
+	Count     int    `json:"count"`
+	Hits      uint64 `json:"hits"`      //Value命中的次数
+	Misses    uint64 `json:"misses"`    //Value未命中的次数，包括之后通过loadData加载成功的
+	Evictions uint64 `json:"evictions"` //被淘汰策略移除的次数
+}
+
+func (table *CacheTable) Name() string {
+	return table.name
+}
+
+func (table *CacheTable) Stats() TableStats {
+	return TableStats{
+		Name:      table.name,
+		Count:     table.Count(),
+		Hits:      atomic.LoadUint64(&table.hits),
+		Misses:    atomic.LoadUint64(&table.misses),
+		Evictions: atomic.LoadUint64(&table.evictions),
+	}
+}
+
+//基于Iterator的快照遍历，trans执行期间不持有table的锁，可以在trans中读写甚至删除table中的item
 func (table *CacheTable) Foreach(trans func(key interface{}, item *CacheItem)) {
-	table.Lock()
-	for key, item := range table.items {
-		trans(key, item) //锁住的范围有点大
+	for it := table.Iterator(); it.Next(); {
+		trans(it.Key(), it.Item())
 	}
-	table.Unlock()
 }
 
 //向table中添加item对象，lifeSpan 为0 表示永久有效 会有覆盖添加的情况发生
+//设置了write-through的Writer且写入失败时返回nil，需要错误信息时使用Put
 func (table *CacheTable) Add(key, data interface{}, lifeSpan time.Duration) *CacheItem {
-	item := NewCacheItem(key, data, lifeSpan)
-	table.addInternal(item)
+	item, _ := table.Put(key, data, lifeSpan)
+	return item
+}
+
+//与Add相同，但返回write-through Writer的错误，出错时table不会被修改
+func (table *CacheTable) Put(key, data interface{}, lifeSpan time.Duration) (*CacheItem, error) {
+	item := NewCacheItem(key, data, table.effectiveLifeSpan(lifeSpan))
+	if err := table.addInternal(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//添加带标签的item，之后可以通过KeysByTag、InvalidateTag按标签查询和批量删除
+func (table *CacheTable) AddWithTags(key, data interface{}, lifeSpan time.Duration, tags ...string) *CacheItem {
+	item := NewCacheItem(key, data, table.effectiveLifeSpan(lifeSpan))
+	item.tags = append([]string(nil), tags...) //调用方之后修改tags不影响标签索引
+	if table.addInternal(item) != nil {
+		return nil
+	}
 	return item
 }
 
-func (table *CacheTable) addInternal(item *CacheItem) {
+func (table *CacheTable) addInternal(item *CacheItem) error {
+	_, err := table.addIf(item, nil)
+	return err
+}
+
+//在写锁内先用cond检查key当前对应的item(不存在时为nil)，cond返回true才写入，cond为nil表示无条件写入
+//检查与写入在同一个临界区内完成，保证了NotFoundAdd、CompareAndSwap这类操作的原子性
+//设置了write-through的Writer时由addThrough处理，写入失败时不修改table并返回错误
+func (table *CacheTable) addIf(item *CacheItem, cond func(old *CacheItem) bool) (bool, error) {
+	table.RLock()
+	writer := table.writer
+	table.RUnlock()
+	if writer != nil {
+		return table.addThrough(writer, item, cond)
+	}
+	return table.commitAdd(item, cond, nil), nil
+}
+
+//write-through的写入：后端I/O不持有table的锁，只持有key所在分段的keyLock
+//同一个key的修改都经过keyLock串行，cond的检查、后端写入和table的修改对这个key仍是原子的，
+//期间只有过期、淘汰和Flush可能移除旧item，它们不通知Writer，不影响后端与table的一致
+func (table *CacheTable) addThrough(writer Writer, item *CacheItem, cond func(old *CacheItem) bool) (bool, error) {
+	mu := table.keyLock(item.key)
+	mu.Lock()
+	table.RLock()
+	old := table.items[item.key]
+	table.RUnlock()
+	if cond != nil && !cond(old) {
+		mu.Unlock()
+		return false, nil
+	}
+	op := WriteOp{Type: WriteAdd, Key: item.key, Data: item.data, LifeSpan: item.LifeSpan()}
+	if old != nil {
+		op.Type = WriteUpdate
+	}
+	if err := writer.Write([]WriteOp{op}); err != nil {
+		mu.Unlock()
+		table.RLock()
+		table.log("Writing item with key ", item.key, " of table ", table.name, " failed: ", err)
+		table.RUnlock()
+		return false, err
+	}
+	//cond已经检查过，可能有副作用，不再重复执行；回调之前释放keyLock，回调中可以再修改这个key
+	return table.commitAdd(item, nil, mu.Unlock), nil
+}
+
+//修改table，release不为nil时在释放table的锁之后、执行回调之前调用
+func (table *CacheTable) commitAdd(item *CacheItem, cond func(old *CacheItem) bool, release func()) bool {
 	table.Lock()
-	table.log("Adding item with key ", item.key, " and life span of ", item.lifeSpan, " to table ", table.name)
+	old := table.items[item.key]
+	if cond != nil && !cond(old) {
+		table.Unlock()
+		return false
+	}
+	op := WriteOp{Type: WriteAdd, Key: item.key, Data: item.data, LifeSpan: item.LifeSpan()}
+	if old != nil {
+		op.Type = WriteUpdate
+	}
+	table.enqueueWrite(op)
+	table.tracer.record(TraceAdd, item.key, item.data, old != nil)
+	table.log("Adding item with key ", item.key, " and life span of ", item.LifeSpan(), " to table ", table.name)
+	if old != nil { //覆盖添加时先移除旧item的索引
+		table.unindexItem(old)
+	}
 	table.items[item.key] = item
+	table.indexItem(item)
+	table.publish(EventAdded, item.key, item)
+	var evicted []*CacheItem
+	if table.policy != nil {
+		table.drainAccesses()
+		if old == nil {
+			evicted = table.evictLocked(table.policy.Add(item.key))
+		} else {
+			table.policy.Access(item.key)
+		}
+	}
 	//利用临时变量缩短临界区
 	expDur := table.cleanupInterval
 	addedItem := table.addedItem
+	aboutToDeleteItem := table.aboutToDeleteItem
 	table.Unlock()
+	if release != nil {
+		release()
+	}
+	for _, e := range evicted {
+		e.notifyDelete(aboutToDeleteItem)
+	}
 	//个人认为这里callback（item）并不安全，callback就算修改item，那也只是顺序修改，这里创建的对象并没有被其他goroutine访问到
 	if addedItem != nil {
 		for _, callback := range addedItem {
@@ -115,10 +280,30 @@ func (table *CacheTable) addInternal(item *CacheItem) {
 		}
 	}
 
-	if item.lifeSpan > 0 && (expDur == 0 || item.lifeSpan < expDur) {
+	if lifeSpan := item.LifeSpan(); lifeSpan > 0 && (expDur == 0 || lifeSpan < expDur) {
 		//当对象有超时信息，需要过期检查
 		table.expirationCheck()
 	}
+	return true
+}
+
+//计算item实际的生命周期：替换DefaultExpiration并叠加随机抖动
+func (table *CacheTable) effectiveLifeSpan(lifeSpan time.Duration) time.Duration {
+	table.RLock()
+	defaultLifeSpan := table.defaultLifeSpan
+	jitter := table.lifeSpanJitter
+	table.RUnlock()
+
+	if lifeSpan == DefaultExpiration {
+		lifeSpan = defaultLifeSpan
+	}
+	if lifeSpan > 0 && jitter > 0 {
+		lifeSpan += time.Duration(float64(lifeSpan) * jitter / 100 * (2*rand.Float64() - 1))
+		if lifeSpan <= 0 { //抖动100%时可能为0，而0表示永久有效
+			lifeSpan = 1
+		}
+	}
+	return lifeSpan
 }
 
 //对整个table中所有item记录整体做过期检查
@@ -136,43 +321,98 @@ func (table *CacheTable) expirationCheck() {
 	//过期检查是对于整个items进行的
 	now := time.Now()
 	smallestDuration := 0 * time.Second
-	for key, item := range table.items {
-		//获取每个item的过期信息,缩减临界区
-		item.RLock()
-		lifeSpan := item.lifeSpan
-		accessedOn := item.accessedOn
-		item.RUnlock()
-
-		if lifeSpan == 0 { //该item永久有效
+	expired := []*CacheItem{}
+	for _, item := range table.items {
+		//获取每个item的过期信息，访问信息是原子读写的，不需要加item的锁
+		if item.LifeSpan() == 0 { //该item永久有效
 			continue
 		}
-		if now.Sub(accessedOn) > lifeSpan { //item过期 删除item
-			table.deleteInternal(key)
+		remaining := item.remaining(now)
+		if remaining < 0 { //item过期，先记下来，删除时会解锁执行回调，不能在遍历map时进行
+			expired = append(expired, item)
 		} else { //item未过期，更新查找table中距离过期最近的时间
-			if smallestDuration == 0 || lifeSpan-now.Sub(accessedOn) < smallestDuration {
-				smallestDuration = lifeSpan - now.Sub(accessedOn)
+			if smallestDuration == 0 || remaining < smallestDuration {
+				smallestDuration = remaining
 			}
 		}
+	}
 
-		table.cleanupInterval = smallestDuration //cleanup定时器将在最近过期的时间触发回调，删除过期item
-		if smallestDuration > 0 {                //设置超时回调，回调永远在本函数结束后被触发，所以不会出现死锁的情况
-			table.cleanupTimer = time.AfterFunc(smallestDuration, func() { //这样确保了每次临近超时都会有goroutine处理
-				go table.expirationCheck() //有必要go出去吗
-			})
-		}
+	table.cleanupInterval = smallestDuration //cleanup定时器将在最近过期的时间触发回调，删除过期item
+	if smallestDuration > 0 {                //设置超时回调，回调永远在本函数结束后被触发，所以不会出现死锁的情况
+		table.cleanupTimer = time.AfterFunc(smallestDuration, func() { //这样确保了每次临近超时都会有goroutine处理
+			go table.expirationCheck() //有必要go出去吗
+		})
 	}
 	table.Unlock()
+
+	for _, item := range expired {
+		if item.expired(time.Now()) { //解锁后item可能又被访问过
+			table.deleteItem(item.key, item, EventExpired)
+		}
+	}
 }
 
-//deleteInternal的实现有点想条件变量，内部件解锁后加锁，以便达到减少临界区的目的
-func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
+//删除key对应的item，old不为nil时只有key当前对应的仍是old才删除
+//回调在table锁之外执行，以便达到减少临界区的目的，回调期间并发的删除只有一个会成功
+//主动删除时先通知Writer，write-through失败时不执行回调也不修改table
+func (table *CacheTable) deleteItem(key interface{}, old *CacheItem, reason EventType) (*CacheItem, error) {
+	table.RLock()
 	item, ok := table.items[key]
-	if !ok {
+	aboutToDeleteItem := table.aboutToDeleteItem
+	writer := table.writer
+	table.RUnlock()
+	if !ok || old != nil && item != old {
 		return nil, ErrNotFound
 	}
-	aboutToDeleteItem := table.aboutToDeleteItem
-	table.Unlock() //先解锁减少临界区域
+	if reason == EventDeleted && writer != nil { //过期不是对数据的修改，不通知Writer
+		if err := table.deleteThrough(writer, key, item); err != nil {
+			return nil, err
+		}
+	}
 	//aboutToDeleteItem回调的触发时间先于delete
+	item.notifyDelete(aboutToDeleteItem)
+
+	table.Lock()
+	defer table.Unlock()
+	//回调期间该key可能已被删除或覆盖添加，只删除仍是当前item的记录
+	if cur, ok := table.items[key]; !ok || cur != item {
+		return nil, ErrNotFound
+	}
+	if reason == EventDeleted {
+		table.enqueueWrite(WriteOp{Type: WriteDelete, Key: key})
+	}
+	table.log("Deleting item with key ", key, "created on ", item.createdOn, " and hit ", item.AccessedCount(), " from table", table.name)
+	delete(table.items, key)
+	table.unindexItem(item)
+	if table.policy != nil {
+		table.policy.Remove(key)
+	}
+	table.publish(reason, key, item)
+	return item, nil
+}
+
+//在keyLock内确认item仍是key当前的值后从后端删除，与addThrough一样不持有table的锁
+func (table *CacheTable) deleteThrough(writer Writer, key interface{}, item *CacheItem) error {
+	mu := table.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	table.RLock()
+	cur, ok := table.items[key]
+	table.RUnlock()
+	if !ok || cur != item {
+		return ErrNotFound
+	}
+	if err := writer.Write([]WriteOp{{Type: WriteDelete, Key: key}}); err != nil {
+		table.RLock()
+		table.log("Writing deletion of key ", key, " of table ", table.name, " failed: ", err)
+		table.RUnlock()
+		return err
+	}
+	return nil
+}
+
+//执行item被删除时的回调
+func (item *CacheItem) notifyDelete(aboutToDeleteItem []func(item *CacheItem)) {
 	for _, callback := range aboutToDeleteItem {
 		callback(item) //这里的操作可能会有数据一致性的问题吧？
 	}
@@ -180,20 +420,75 @@ func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
 	item.RLock()
 	if item.aboutToExpire != nil {
 		for _, callback := range item.aboutToExpire {
-			callback(key) //这里的操作可能会有数据一致性的问题吧？
+			callback(item.key) //这里的操作可能会有数据一致性的问题吧？
 		}
 	}
 	item.RUnlock()
-	table.Lock()
-	table.log("Deleting item with key ", key, "created on ", item.createdOn, " and hit ", item.accessedCount, " from table", table.name)
-	delete(table.items, key)
-	return item, nil
+}
+
+//维护item相关的索引，调用方需持有table的写锁
+func (table *CacheTable) indexItem(item *CacheItem) {
+	if table.keyIndex != nil {
+		if key, ok := item.key.(string); ok {
+			table.keyIndex.insert(key)
+		}
+	}
+	for _, tag := range item.tags {
+		if table.tagIndex == nil {
+			table.tagIndex = make(map[string]map[interface{}]struct{})
+		}
+		keys, ok := table.tagIndex[tag]
+		if !ok {
+			keys = make(map[interface{}]struct{})
+			table.tagIndex[tag] = keys
+		}
+		keys[item.key] = struct{}{}
+	}
+}
+
+func (table *CacheTable) unindexItem(item *CacheItem) {
+	if table.keyIndex != nil {
+		if key, ok := item.key.(string); ok {
+			table.keyIndex.remove(key)
+		}
+	}
+	for _, tag := range item.tags {
+		keys, ok := table.tagIndex[tag]
+		if !ok {
+			continue
+		}
+		delete(keys, item.key)
+		if len(keys) == 0 { //标签下没有key了就回收掉
+			delete(table.tagIndex, tag)
+		}
+	}
 }
 
 func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
-	table.Lock()
-	defer table.Unlock()
-	return table.deleteInternal(key)
+	item, err := table.deleteItem(key, nil, EventDeleted)
+	table.traceDelete(key, item, err)
+	return item, err
+}
+
+//只有当old仍是key当前对应的item时才删除，否则返回ErrNotFound，用于撤销自己的写入而不影响并发写入的新值
+//与Delete一样通知Writer并执行删除回调
+func (table *CacheTable) CompareAndDelete(old *CacheItem) error {
+	item, err := table.deleteItem(old.key, old, EventDeleted)
+	table.traceDelete(old.key, item, err)
+	return err
+}
+
+func (table *CacheTable) traceDelete(key interface{}, item *CacheItem, err error) {
+	table.RLock()
+	tracer := table.tracer
+	table.RUnlock()
+	if tracer != nil {
+		var data interface{}
+		if item != nil {
+			data = item.data
+		}
+		tracer.record(TraceDelete, key, data, err == nil)
+	}
 }
 
 func (table *CacheTable) Exists(key interface{}) bool {
@@ -203,31 +498,115 @@ func (table *CacheTable) Exists(key interface{}) bool {
 	return ok
 }
 
+//key不存在时才添加，检查与添加是原子的
 func (table *CacheTable) NotFoundAdd(key, data interface{}, lifeSpan time.Duration) bool {
-	if table.Exists(key) {
-		return false
+	added, _ := table.NotFoundPut(key, data, lifeSpan)
+	return added
+}
+
+//与NotFoundAdd相同，但返回write-through Writer的错误
+func (table *CacheTable) NotFoundPut(key, data interface{}, lifeSpan time.Duration) (bool, error) {
+	item := NewCacheItem(key, data, table.effectiveLifeSpan(lifeSpan))
+	return table.addIf(item, func(old *CacheItem) bool {
+		return old == nil
+	})
+}
+
+//只有当old仍是key当前对应的item时，才用新的data和lifeSpan替换它，用于实现无锁的读-改-写
+//替换后的item是一个新的item，不会继承old的回调和标签
+func (table *CacheTable) CompareAndSwap(old *CacheItem, data interface{}, lifeSpan time.Duration) (*CacheItem, bool) {
+	item, swapped, _ := table.CompareAndPut(old, data, lifeSpan)
+	return item, swapped
+}
+
+//与CompareAndSwap相同，但返回write-through Writer的错误，出错时swapped为false
+func (table *CacheTable) CompareAndPut(old *CacheItem, data interface{}, lifeSpan time.Duration) (*CacheItem, bool, error) {
+	item := NewCacheItem(old.key, data, table.effectiveLifeSpan(lifeSpan))
+	if swapped, err := table.addIf(item, func(cur *CacheItem) bool {
+		return cur == old
+	}); !swapped {
+		return nil, false, err
 	}
-	item := NewCacheItem(key, data, lifeSpan)
-	table.addInternal(item)
-	return true
+	return item, true, nil
+}
+
+//与CompareAndPut相同，但只替换data，新item保留old剩余的生命周期，不重新计算默认生命周期和抖动
+//用于INCR这类只修改值、不应影响过期时间的读-改-写
+func (table *CacheTable) CompareAndPutData(old *CacheItem, data interface{}) (*CacheItem, bool, error) {
+	lifeSpan := old.LifeSpan()
+	if lifeSpan > 0 {
+		if lifeSpan = old.remaining(time.Now()); lifeSpan <= 0 { //已经过期，等待清理
+			lifeSpan = 1
+		}
+	}
+	item := NewCacheItem(old.key, data, lifeSpan)
+	if swapped, err := table.addIf(item, func(cur *CacheItem) bool {
+		return cur == old
+	}); !swapped {
+		return nil, false, err
+	}
+	return item, true, nil
+}
+
+//查看key对应的item，与Value不同，不会更新访问信息也不会触发loadData
+func (table *CacheTable) Peek(key interface{}) (*CacheItem, error) {
+	table.RLock()
+	item, ok := table.items[key]
+	table.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+//重新设置key的生命周期并刷新访问时间，0表示永久有效
+func (table *CacheTable) Touch(key interface{}, lifeSpan time.Duration) (*CacheItem, error) {
+	lifeSpan = table.effectiveLifeSpan(lifeSpan)
+	table.RLock()
+	item, ok := table.items[key]
+	expDur := table.cleanupInterval
+	table.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	item.touch(lifeSpan)
+
+	if lifeSpan > 0 && (expDur == 0 || lifeSpan < expDur) {
+		table.expirationCheck()
+	}
+	return item, nil
 }
 
 func (table *CacheTable) Value(key interface{}, args ...interface{}) (*CacheItem,error) {
 	table.RLock()//减少临界区域
 	item, ok := table.items[key]
 	loadData:=table.loadData
+	accesses := table.accesses
+	tracer := table.tracer
+	hotKeys := table.hotKeys
 	table.RUnlock()
 
+	if hotKeys != nil {
+		hotKeys.record(key, time.Now())
+	}
 	if ok{//被访问后更新访问信息
+		atomic.AddUint64(&table.hits, 1)
 		item.KeepAlive()
+		if accesses != nil {
+			table.recordAccess(accesses, key)
+		}
+		tracer.record(TraceValue, key, item.data, true)
 		return item,nil
 	}
+	atomic.AddUint64(&table.misses, 1)
+	tracer.record(TraceValue, key, nil, false)
 	//当试图访问一个不存在的key时
 	if loadData!=nil{
 		item=loadData(key,args)//先触发访问不存在key时的回调
 		if item !=nil{
-			table.Add(key,item.data,item.lifeSpan)
-			return item,nil
+			//返回真正加入table的item，其生命周期已经过默认值与抖动处理
+			return table.Put(key,item.data,item.LifeSpan())
 		}
 		return nil,ErrNotFoundOrLoadable
 	}
@@ -241,6 +620,15 @@ func (table *CacheTable) Flush() {
 	table.Lock()
 	table.log("Flushing table ",table.name)
 	table.items=make(map[interface{}]*CacheItem)//丢弃所有的item指向新的内存
+	if table.keyIndex != nil {
+		table.keyIndex = newKeyIndex()
+	}
+	table.tagIndex = nil
+	if table.policy != nil {
+		table.drainAccesses()
+		table.policy.Reset()
+	}
+	table.publish(EventFlushed, nil, nil)
 	table.cleanupInterval = 0
 	if table.cleanupTimer!=nil{
 		table.cleanupTimer.Stop()
@@ -248,6 +636,83 @@ func (table *CacheTable) Flush() {
 	table.Unlock()
 }
 
+//开启有序key索引，开启后可以使用Scan、Range、DeletePrefix按字典序查询string类型的key
+func (table *CacheTable) EnableKeyIndex() {
+	table.Lock()
+	if table.keyIndex == nil {
+		table.keyIndex = newKeyIndex()
+		for _, item := range table.items {
+			table.indexItem(item)
+		}
+	}
+	table.Unlock()
+}
+
+//按字典序返回所有以prefix开头的key
+func (table *CacheTable) Scan(prefix string) ([]string, error) {
+	table.RLock()
+	defer table.RUnlock()
+	if table.keyIndex == nil {
+		return nil, ErrKeyIndexDisabled
+	}
+	return table.keyIndex.prefix(prefix), nil
+}
+
+//按字典序返回[from, to)区间内的key，to为空表示没有上界
+func (table *CacheTable) Range(from, to string) ([]string, error) {
+	table.RLock()
+	defer table.RUnlock()
+	if table.keyIndex == nil {
+		return nil, ErrKeyIndexDisabled
+	}
+	return table.keyIndex.between(from, to), nil
+}
+
+//删除所有以prefix开头的key，与Delete一样会触发删除回调，返回删除的个数
+func (table *CacheTable) DeletePrefix(prefix string) (int, error) {
+	keys, err := table.Scan(prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, key := range keys {
+		if _, err := table.Delete(key); err == nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+//返回带有tag标签的所有key
+func (table *CacheTable) KeysByTag(tag string) []interface{} {
+	table.RLock()
+	defer table.RUnlock()
+	keys := make([]interface{}, 0, len(table.tagIndex[tag]))
+	for key := range table.tagIndex[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+//删除带有tag标签的所有item，与Delete一样会触发删除回调，返回删除的个数
+//key在取出之后可能被覆盖为不带tag的新item，只删除检查过标签的那个item，deleteItem在锁内确认它仍是当前的值
+func (table *CacheTable) InvalidateTag(tag string) int {
+	deleted := 0
+	for _, key := range table.KeysByTag(tag) {
+		item, err := table.Peek(key)
+		if err != nil || !item.hasTag(tag) {
+			continue
+		}
+		item, err = table.deleteItem(key, item, EventDeleted)
+		table.traceDelete(key, item, err)
+		if err == nil {
+			deleted++
+		}
+	}
+	table.log("Invalidated ", deleted, " items with tag ", tag, " from table ", table.name)
+	return deleted
+}
+
 type CacheItemPair struct {
 	Key interface{}
 	AccessedCount int64
@@ -264,31 +729,12 @@ func (p CacheItemPairList)Len() int {
 func (p CacheItemPairList)Less(i,j int)bool  {//i>j 就不换从大到小排序
 	return p[i].AccessedCount > p[j].AccessedCount
 }
-//获取前count个 访问次数较多的item
-func (table *CacheTable) MostAccessed(count int)[]*CacheItem {
-	itemPairList:=make(CacheItemPairList,len(table.items))
-	table.RLock()//用一个范围较大的锁，保证了改阶段中不会被其他线程阻塞
-	i:=0
-	for k,v:=range table.items{
-		itemPairList[i] = CacheItemPair{k,v.accessedCount}
-		i++
-	}
-
-	sort.Sort(itemPairList)
-
-	returnItems:=[]*CacheItem{}
-	for i,v:=range itemPairList{
-		if i < count{
-			//table.RLock()
-			item,ok:= table.items[v.Key]
-			if ok{
-				returnItems = append(returnItems,item)
-			}
-			//table.RUnlock()
-		}
+//获取前count个 访问次数较多的item，count<=0时返回空切片
+func (table *CacheTable) MostAccessed(count int) []*CacheItem {
+	if count <= 0 { //Select的limit<=0表示不限制，这里保持原来的行为
+		return []*CacheItem{}
 	}
-	table.RUnlock()
-	return returnItems
+	return table.Select(nil, ByMostAccessed, count)
 }
 
 func (table *CacheTable) log(v ...interface{}) {
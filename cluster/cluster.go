@@ -0,0 +1,228 @@
+//Package cluster 把一个逻辑上的缓存分布到多个进程上。
+//
+//Cluster在客户端用一致性哈希把key分配给各个节点，每个节点运行NodeServer包装本地的CacheTable。
+//健康检查失败的节点会暂时从哈希环上摘除，它负责的key由环上的下一个节点接管，恢复后再加回。
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+var ErrNoNodes = errors.New("cluster: no healthy nodes")
+
+type node struct {
+	name    string
+	addr    string //节点的base URL，如http://127.0.0.1:8080
+	weight  int
+	healthy bool
+}
+
+type Cluster struct {
+	sync.Mutex
+
+	ring   *Ring //只包含健康的节点
+	nodes  map[string]*node
+	client *http.Client
+	stop   chan struct{}
+}
+
+//replicas为每单位权重的虚拟节点数，client为nil时使用http.DefaultClient
+func NewCluster(replicas int, client *http.Client) *Cluster {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Cluster{
+		ring:   NewRing(replicas),
+		nodes:  make(map[string]*node),
+		client: client,
+	}
+}
+
+//加入节点，新节点默认是健康的
+func (c *Cluster) AddNode(name, addr string, weight int) {
+	c.Lock()
+	c.nodes[name] = &node{name: name, addr: addr, weight: weight, healthy: true}
+	c.Unlock()
+	c.ring.Add(name, weight)
+}
+
+func (c *Cluster) RemoveNode(name string) {
+	c.Lock()
+	delete(c.nodes, name)
+	c.Unlock()
+	c.ring.Remove(name)
+}
+
+//当前负责key的节点名
+func (c *Cluster) NodeFor(key string) (string, bool) {
+	return c.ring.Get(key)
+}
+
+//当前健康的节点名
+func (c *Cluster) HealthyNodes() []string {
+	return c.ring.Members()
+}
+
+func (c *Cluster) nodeFor(key string) (*node, error) {
+	name, ok := c.ring.Get(key)
+	if !ok {
+		return nil, ErrNoNodes
+	}
+	c.Lock()
+	n, ok := c.nodes[name]
+	c.Unlock()
+	if !ok {
+		return nil, ErrNoNodes
+	}
+	return n, nil
+}
+
+func (c *Cluster) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	n, err := c.nodeFor(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, n.addr+"/cache/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return c.client.Do(req.WithContext(ctx))
+}
+
+//key不存在时返回memory_cache.ErrNotFound
+func (c *Cluster) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, memory_cache.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster: get %q: %s", key, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+//lifeSpan为memory_cache.DefaultExpiration时使用节点上表的默认生命周期
+func (c *Cluster) Set(ctx context.Context, key string, value []byte, lifeSpan time.Duration) error {
+	header := http.Header{}
+	header.Set(LifeSpanHeader, strconv.FormatInt(int64(lifeSpan), 10))
+	resp, err := c.do(ctx, http.MethodPut, key, value, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("cluster: set %q: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (c *Cluster) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("cluster: delete %q: %s", key, resp.Status)
+	}
+	return nil
+}
+
+//对所有节点做一轮健康检查，根据结果把节点摘除或加回哈希环
+func (c *Cluster) CheckHealth(ctx context.Context) {
+	c.Lock()
+	nodes := make([]*node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	c.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			healthy := c.ping(ctx, n)
+			c.Lock()
+			defer c.Unlock()
+			if cur, ok := c.nodes[n.name]; !ok || cur != n || n.healthy == healthy {
+				return
+			}
+			n.healthy = healthy
+			if healthy {
+				c.ring.Add(n.name, n.weight)
+			} else {
+				c.ring.Remove(n.name)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func (c *Cluster) ping(ctx context.Context, n *node) bool {
+	req, err := http.NewRequest(http.MethodGet, n.addr+"/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+//每隔interval做一次健康检查，直到Close
+func (c *Cluster) StartHealthChecks(interval, timeout time.Duration) {
+	c.Lock()
+	if c.stop != nil {
+		c.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	c.stop = stop
+	c.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				c.CheckHealth(ctx)
+				cancel()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//停止健康检查
+func (c *Cluster) Close() {
+	c.Lock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.Unlock()
+}
@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func TestRingDistribution(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	if _, ok := r.Get("k"); ok {
+		t.Error("empty ring should not own keys")
+	}
+	r.Add("a", 1)
+	r.Add("b", 1)
+	r.Add("c", 2)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		member, _ := r.Get(fmt.Sprint("key", i))
+		counts[member]++
+	}
+	//c的权重是其他节点的两倍，应该分到约一半的key
+	if counts["c"] < 4000 || counts["c"] > 6500 || counts["a"] < 1500 || counts["b"] < 1500 {
+		t.Error("keys are not distributed by weight", counts)
+	}
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Add("a", 1)
+	r.Add("b", 1)
+	r.Add("c", 1)
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint("key", i)
+		before[key], _ = r.Get(key)
+	}
+
+	r.Add("d", 1)
+	moved := 0
+	for key, owner := range before {
+		cur, _ := r.Get(key)
+		if cur != owner {
+			moved++
+			if cur != "d" {
+				t.Fatal("keys should only move to the new member", key, owner, cur)
+			}
+		}
+	}
+	if moved < 1500 || moved > 3500 { //理论上约1/4的key迁移
+		t.Error("unexpected number of moved keys", moved)
+	}
+
+	r.Remove("d")
+	for key, owner := range before {
+		if cur, _ := r.Get(key); cur != owner {
+			t.Fatal("removing the member should restore ownership", key)
+		}
+	}
+	if members := r.Members(); len(members) != 3 {
+		t.Error("wrong members", members)
+	}
+}
+
+func startNodes(t *testing.T, c *Cluster, n int) []*httptest.Server {
+	servers := make([]*httptest.Server, n)
+	for i := range servers {
+		name := fmt.Sprintf("%s-node%d", t.Name(), i)
+		servers[i] = httptest.NewServer(NewNodeServer(memory_cache.Cache(name)))
+		c.AddNode(name, servers[i].URL, 1)
+	}
+	return servers
+}
+
+func TestCluster(t *testing.T) {
+	c := NewCluster(DefaultReplicas, nil)
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "k"); err != ErrNoNodes {
+		t.Error("empty cluster should fail", err)
+	}
+	servers := startNodes(t, c, 3)
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if err := c.Set(ctx, fmt.Sprint("key/", i), []byte(fmt.Sprint(i)), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key/", i)
+		value, err := c.Get(ctx, key)
+		if err != nil || string(value) != fmt.Sprint(i) {
+			t.Fatal("wrong value", key, value, err)
+		}
+		//key只保存在负责它的节点上
+		owner, _ := c.NodeFor(key)
+		if !memory_cache.Cache(owner).Exists(key) {
+			t.Fatal("key should be stored on its owner", key, owner)
+		}
+	}
+	total := 0
+	for i := range servers {
+		count := memory_cache.Cache(fmt.Sprintf("%s-node%d", t.Name(), i)).Count()
+		if count == 0 {
+			t.Error("node should own some keys", i)
+		}
+		total += count
+	}
+	if total != 100 {
+		t.Error("keys should not be duplicated", total)
+	}
+
+	if err := c.Delete(ctx, "key/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "key/1"); err != memory_cache.ErrNotFound {
+		t.Error("deleted key should not be found", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	c := NewCluster(DefaultReplicas, nil)
+	servers := startNodes(t, c, 2)
+	defer servers[0].Close()
+	down := fmt.Sprintf("%s-node1", t.Name())
+
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint("key", i)
+		if owner, _ := c.NodeFor(key); owner == down {
+			break
+		}
+	}
+
+	servers[1].Close()
+	c.CheckHealth(context.Background())
+	if nodes := c.HealthyNodes(); len(nodes) != 1 {
+		t.Fatal("unhealthy node should be removed from ring", nodes)
+	}
+	ctx := context.Background()
+	if err := c.Set(ctx, key, []byte("v"), 0); err != nil {
+		t.Fatal("key of unhealthy node should be served by another node", err)
+	}
+	if value, err := c.Get(ctx, key); err != nil || string(value) != "v" {
+		t.Error("wrong value", value, err)
+	}
+
+	//节点恢复后重新加入
+	servers[1] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer servers[1].Close()
+	c.RemoveNode(down)
+	c.AddNode(down, servers[1].URL, 1)
+	c.CheckHealth(ctx)
+	if nodes := c.HealthyNodes(); len(nodes) != 2 {
+		t.Error("healthy node should stay in ring", nodes)
+	}
+
+	c.StartHealthChecks(10*time.Millisecond, time.Second)
+	defer c.Close()
+	servers[1].Close()
+	time.Sleep(100 * time.Millisecond)
+	if nodes := c.HealthyNodes(); len(nodes) != 1 {
+		t.Error("background health checks should remove unhealthy nodes", nodes)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(ops []memory_cache.WriteOp) error {
+	return fmt.Errorf("backend down")
+}
+
+func TestClusterLifeSpan(t *testing.T) {
+	c := NewCluster(DefaultReplicas, nil)
+	ctx := context.Background()
+	servers := startNodes(t, c, 1)
+	defer servers[0].Close()
+	table := memory_cache.Cache(t.Name() + "-node0")
+	table.SetDefaultLifeSpan(time.Hour)
+
+	//不足1毫秒的生命周期不能变成永久有效
+	c.Set(ctx, "short", []byte("v"), 500*time.Microsecond)
+	if item, err := table.Peek("short"); err == nil && item.LifeSpan() != 500*time.Microsecond {
+		t.Error("sub-millisecond life span should be kept", item.LifeSpan())
+	}
+	c.Set(ctx, "default", []byte("v"), memory_cache.DefaultExpiration)
+	if item, _ := table.Peek("default"); item.LifeSpan() != time.Hour {
+		t.Error("DefaultExpiration should use the node's default", item.LifeSpan())
+	}
+
+	table.SetWriteThrough(failingWriter{})
+	defer table.SetWriteThrough(nil)
+	if err := c.Set(ctx, "k", []byte("v"), 0); err == nil || table.Exists("k") {
+		t.Error("write-through failure should be reported", err)
+	}
+	if err := c.Delete(ctx, "default"); err == nil || !table.Exists("default") {
+		t.Error("write-through delete failure should be reported", err)
+	}
+	if err := c.Delete(ctx, "missing"); err != nil {
+		t.Error("deleting a missing key is not an error", err)
+	}
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//节点之间传递生命周期的请求头，单位纳秒，与time.Duration一致
+//-1即memory_cache.DefaultExpiration，表示使用节点上表的默认生命周期
+const LifeSpanHeader = "X-Cache-Life-Span-Ns"
+
+//包装本地CacheTable的最小HTTP节点服务
+//
+//	GET    /cache/{key}  读取value，不存在返回404
+//	PUT    /cache/{key}  请求体为value，生命周期由LifeSpanHeader指定
+//	DELETE /cache/{key}
+//	GET    /health       健康检查
+type NodeServer struct {
+	table *memory_cache.CacheTable
+}
+
+func NewNodeServer(table *memory_cache.CacheTable) *NodeServer {
+	return &NodeServer{table: table}
+}
+
+func (s *NodeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(r.URL.EscapedPath(), "/cache/") {
+		http.NotFound(w, r)
+		return
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/cache/"))
+	if err != nil || key == "" {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		item, err := s.table.Value(key)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		value, ok := item.Data().([]byte)
+		if !ok {
+			http.Error(w, "value is not []byte", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+	case http.MethodPut:
+		ns, err := strconv.ParseInt(r.Header.Get(LifeSpanHeader), 10, 64)
+		lifeSpan := time.Duration(ns)
+		if err != nil && r.Header.Get(LifeSpanHeader) != "" || lifeSpan < 0 && lifeSpan != memory_cache.DefaultExpiration {
+			http.Error(w, "invalid life span", http.StatusBadRequest)
+			return
+		}
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := s.table.Put(key, value, lifeSpan); err != nil { //write-through失败
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		//删除不存在的key不是错误，write-through失败时返回500
+		if _, err := s.table.Delete(key); err != nil && err != memory_cache.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const DefaultReplicas = 100
+
+//一致性哈希环
+//每个成员按权重在环上放置weight*replicas个虚拟节点，增删成员时只有相邻区间的key会迁移
+type Ring struct {
+	sync.RWMutex
+
+	replicas int
+	hashes   []uint32          //排好序的虚拟节点哈希值
+	owners   map[uint32]string //虚拟节点到成员的映射
+	weights  map[string]int
+}
+
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		weights:  make(map[string]int),
+	}
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+//加入成员，已存在时更新其权重，weight<=0按1处理
+func (r *Ring) Add(member string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.weights[member]; ok {
+		r.removeLocked(member)
+	}
+	r.weights[member] = weight
+	for i := 0; i < weight*r.replicas; i++ {
+		h := hashKey(strconv.Itoa(i) + "#" + member)
+		if _, ok := r.owners[h]; ok { //哈希冲突时保留先加入的虚拟节点
+			continue
+		}
+		r.owners[h] = member
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+}
+
+func (r *Ring) Remove(member string) {
+	r.Lock()
+	r.removeLocked(member)
+	r.Unlock()
+}
+
+func (r *Ring) removeLocked(member string) {
+	if _, ok := r.weights[member]; !ok {
+		return
+	}
+	delete(r.weights, member)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == member {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+//key所属的成员，环为空时返回false
+func (r *Ring) Get(key string) (string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) { //环形，越过最大值回到起点
+		i = 0
+	}
+	return r.owners[r.hashes[i]], true
+}
+
+//按名字排序的所有成员
+func (r *Ring) Members() []string {
+	r.RLock()
+	members := make([]string, 0, len(r.weights))
+	for member := range r.weights {
+		members = append(members, member)
+	}
+	r.RUnlock()
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func TestReadTrace(t *testing.T) {
+	reqs, err := readTrace(strings.NewReader("a\n\nb\na\n"), formatAuto, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 3 || reqs[2].key != "a" || reqs[2].time.Sub(reqs[0].time) != 2*time.Second {
+		t.Fatalf("plain trace parsed as %+v", reqs)
+	}
+
+	//带表头时列的顺序由表头决定
+	csv := "size,key,timestamp\n10,a,100\n20,b,100.5\n"
+	reqs, err = readTrace(strings.NewReader(csv), formatAuto, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[1].key != "b" || reqs[1].size != 20 || reqs[1].time.Sub(reqs[0].time) != 500*time.Millisecond {
+		t.Fatalf("csv trace parsed as %+v", reqs)
+	}
+
+	reqs, err = readTrace(strings.NewReader("2020-01-01T00:00:00Z,a,5\n2020-01-01T00:00:01Z,b,7\n"), formatCSV, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].key != "a" || reqs[1].size != 7 || reqs[1].time.Sub(reqs[0].time) != time.Second {
+		t.Fatalf("headerless csv parsed as %+v", reqs)
+	}
+
+	if _, err := readTrace(strings.NewReader("1,a,x\n"), formatCSV, 0); err == nil {
+		t.Fatal("invalid size should fail")
+	}
+}
+
+func keys(s string) []request {
+	var reqs []request
+	now := time.Unix(0, 0)
+	for _, key := range strings.Fields(s) {
+		reqs = append(reqs, request{time: now, key: key, size: 1})
+		now = now.Add(time.Second)
+	}
+	return reqs
+}
+
+func TestSimulatePolicies(t *testing.T) {
+	//a被频繁访问，容量为2时LRU和LFU都能保留a，FIFO会按进入顺序把它淘汰
+	trace := keys("a a a b a c a d a e a")
+	hits := map[string]int{}
+	for _, name := range []string{"lru", "lfu", "fifo", "arc"} {
+		res, err := simulate(config{policy: name, capacity: 2}, trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.requests != len(trace) {
+			t.Fatalf("%s: requests = %d", name, res.requests)
+		}
+		hits[name] = res.hits
+	}
+	if hits["lru"] != 6 || hits["lfu"] != 6 {
+		t.Fatalf("hits = %v", hits)
+	}
+	//FIFO在c进入时淘汰最早进入的a
+	if hits["fifo"] >= hits["lru"] {
+		t.Fatalf("fifo should lose to lru here, hits = %v", hits)
+	}
+
+	if _, err := simulate(config{policy: "nope", capacity: 1}, trace); err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
+
+func TestLRUPolicy(t *testing.T) {
+	p := newLRUPolicy(2)
+	p.Add("a")
+	p.Add("b")
+	p.Access("a")
+	if evicted := p.Add("c"); len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("evicted = %v", evicted)
+	}
+	p.Remove("a")
+	if evicted := p.Add("d"); len(evicted) != 0 {
+		t.Fatalf("removed key should free a slot, evicted = %v", evicted)
+	}
+}
+
+func TestARCPolicy(t *testing.T) {
+	p := newARCPolicy(2)
+	p.Add("a")
+	p.Access("a") //a进入t2
+	//只访问一次的b、c、d依次经过t1，不会把a挤出去
+	for _, key := range []string{"b", "c", "d"} {
+		for _, victim := range p.Add(key) {
+			if victim == "a" {
+				t.Fatalf("scan evicted the frequently used key")
+			}
+		}
+	}
+	//c在b1中，再次加入时增大t1的目标大小并直接进入t2
+	if evicted := p.Add("c"); len(evicted) != 1 || p.p != 1 {
+		t.Fatalf("evicted = %v, p = %d", evicted, p.p)
+	}
+	if e := p.elems["c"].Value.(*arcEntry); e.ll != p.t2 {
+		t.Fatal("ghost hit should go to t2")
+	}
+	if p.t1.Len()+p.t2.Len() != 2 {
+		t.Fatalf("resident = %d", p.t1.Len()+p.t2.Len())
+	}
+	p.Remove("c")
+	if _, ok := p.elems["c"]; ok {
+		t.Fatal("removed key should not be kept")
+	}
+	p.Reset()
+	if len(p.elems) != 0 || p.p != 0 {
+		t.Fatal("Reset failed")
+	}
+
+	//随机的访问、删除序列中，缓存的key不超过容量，ghost队列不超过两倍容量
+	p = newARCPolicy(8)
+	resident := map[interface{}]bool{}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := rnd.Intn(40)
+		switch {
+		case resident[key] && rnd.Intn(10) == 0:
+			p.Remove(key)
+			delete(resident, key)
+		case resident[key]:
+			p.Access(key)
+		default:
+			for _, victim := range p.Add(key) {
+				if !resident[victim] {
+					t.Fatalf("evicted %v which is not cached", victim)
+				}
+				delete(resident, victim)
+			}
+			resident[key] = true
+		}
+		if n := p.t1.Len() + p.t2.Len(); n != len(resident) || n > 8 || len(p.elems) > 16 {
+			t.Fatalf("resident = %d, cached = %d, tracked = %d", n, len(resident), len(p.elems))
+		}
+	}
+}
+
+func TestSimulateTTL(t *testing.T) {
+	//相邻请求间隔1秒，TTL为2秒时a在第4秒再访问已过期，命中后生命周期会被刷新
+	trace := keys("a a b c d a")
+	res, err := simulate(config{policy: "lru", capacity: 10, ttl: 2 * time.Second}, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.hits != 1 || res.expired == 0 {
+		t.Fatalf("hits = %d, expired = %d", res.hits, res.expired)
+	}
+	res, _ = simulate(config{policy: "lru", capacity: 10}, trace)
+	if res.hits != 2 {
+		t.Fatalf("without ttl hits = %d", res.hits)
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(path, []byte("timestamp,key,size\n1,a,10\n2,b,10\n3,a,30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	args := []string{"-trace", path, "-capacities", "1,2", "-ttls", "0,1s", "-policies", "lru,fifo", "-output", "csv"}
+	if err := run(args, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1+2*2*2 {
+		t.Fatalf("output:\n%s", out.String())
+	}
+	//容量2且不过期时a第二次命中，字节命中率为30/50
+	if lines[3] != "lru,2,none,3,1,0.333333,0.600000,0,0" {
+		t.Fatalf("unexpected row %q", lines[3])
+	}
+
+	out.Reset()
+	if err := run([]string{"-capacities", "5", "-policies", "arc"}, strings.NewReader("x\ny\nx\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "hit ratio") || !strings.Contains(out.String(), "0.3333") {
+		t.Fatalf("table output:\n%s", out.String())
+	}
+
+	if err := run([]string{"-policies", "bogus"}, strings.NewReader("x\n"), &out); err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
+
+func TestDumpedTrace(t *testing.T) {
+	table := memory_cache.Cache("TestDumpedTrace")
+	table.EnableTrace(memory_cache.TraceOptions{})
+	table.Value("a")
+	table.Add("a", []byte("x"), 0)
+	table.Value("a")
+	table.Delete("a")
+	table.Value("a")
+
+	var dump bytes.Buffer
+	if err := table.DumpTrace(&dump); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := readTrace(&dump, formatAuto, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 5 || reqs[1].op != opAdd || reqs[3].op != opDelete {
+		t.Fatalf("dumped trace parsed as %+v", reqs)
+	}
+	//只有三次value计入请求，delete之后的value未命中
+	res, err := simulate(config{policy: "lru", capacity: 10}, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.requests != 3 || res.hits != 1 {
+		t.Fatalf("requests = %d, hits = %d", res.requests, res.hits)
+	}
+}
@@ -0,0 +1,144 @@
+//cachesim用访问trace离线模拟不同容量、TTL和淘汰策略下的命中率
+//
+//	cachesim -trace access.log -capacities 1000,10000 -ttls 0,5m -policies lru,lfu,fifo,arc
+//
+//trace可以是每行一个key，也可以是timestamp,key,size格式的CSV(第一行可以是表头)，
+//每行一个key时按-interval为请求生成时间。CacheTable.DumpTrace导出的CSV可以直接使用，
+//其中add和delete操作只改变模拟缓存的内容，不计入请求数
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "cachesim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("cachesim", flag.ContinueOnError)
+	tracePath := fs.String("trace", "-", "trace文件，-表示标准输入")
+	format := fs.String("format", formatAuto, "trace格式: auto、plain或csv")
+	interval := fs.Duration("interval", time.Millisecond, "plain格式中相邻请求的时间间隔")
+	capacities := fs.String("capacities", "100,1000,10000", "逗号分隔的容量(条目数)")
+	ttls := fs.String("ttls", "0", "逗号分隔的生命周期，0表示不过期")
+	policies := fs.String("policies", "lru,lfu,fifo,arc", "逗号分隔的淘汰策略，另外支持2q和tinylfu")
+	output := fs.String("output", "table", "输出格式: table或csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "csv" {
+		return fmt.Errorf("unknown output %q", *output)
+	}
+
+	var caps []int
+	for _, field := range splitList(*capacities) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid capacity %q", field)
+		}
+		caps = append(caps, n)
+	}
+	var lifeSpans []time.Duration
+	for _, field := range splitList(*ttls) {
+		ttl, err := time.ParseDuration(field)
+		if err != nil {
+			return fmt.Errorf("invalid ttl %q", field)
+		}
+		lifeSpans = append(lifeSpans, ttl)
+	}
+	names := splitList(strings.ToLower(*policies))
+	if len(caps) == 0 || len(lifeSpans) == 0 || len(names) == 0 {
+		return fmt.Errorf("capacities, ttls and policies must not be empty")
+	}
+
+	var configs []config
+	for _, name := range names {
+		//提前检查策略名，避免读完trace才报错
+		if _, err := newPolicy(name, 1); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, capacity := range caps {
+			for _, ttl := range lifeSpans {
+				configs = append(configs, config{policy: name, capacity: capacity, ttl: ttl})
+			}
+		}
+	}
+
+	in := stdin
+	if *tracePath != "-" {
+		f, err := os.Open(*tracePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	reqs, err := readTrace(in, *format, *interval)
+	if err != nil {
+		return err
+	}
+
+	results := make([]result, 0, len(configs))
+	for _, cfg := range configs {
+		res, err := simulate(cfg, reqs)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+	if *output == "csv" {
+		return writeCSV(stdout, results)
+	}
+	return writeTable(stdout, results)
+}
+
+func splitList(s string) []string {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func writeTable(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "policy\tcapacity\tttl\trequests\thits\thit ratio\tbyte hit ratio\tevictions\texpired\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%.4f\t%.4f\t%d\t%d\t\n",
+			r.policy, r.capacity, formatTTL(r.ttl), r.requests, r.hits, r.hitRatio(), r.byteHitRatio(), r.evictions, r.expired)
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	if _, err := fmt.Fprintln(w, "policy,capacity,ttl,requests,hits,hit_ratio,byte_hit_ratio,evictions,expired"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "%s,%d,%s,%d,%d,%.6f,%.6f,%d,%d\n",
+			r.policy, r.capacity, formatTTL(r.ttl), r.requests, r.hits, r.hitRatio(), r.byteHitRatio(), r.evictions, r.expired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "none"
+	}
+	return ttl.String()
+}
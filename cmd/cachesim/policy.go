@@ -0,0 +1,317 @@
+package main
+
+import (
+	"container/heap"
+	"container/list"
+	"strings"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//标准的LRU、LFU、FIFO和ARC在这里独立实现，不依赖库中的实现，
+//额外支持的2q和tinylfu直接使用memory_cache的实现，模拟结果与容量受限的CacheTable一致
+func newPolicy(name string, capacity int) (memory_cache.EvictionPolicy, error) {
+	switch strings.ToLower(name) {
+	case "lru":
+		return newLRUPolicy(capacity), nil
+	case "arc":
+		return newARCPolicy(capacity), nil
+	case "fifo":
+		return newFIFOPolicy(capacity), nil
+	case "lfu":
+		return newLFUPolicy(capacity), nil
+	}
+	return memory_cache.NewEvictionPolicy(name, capacity)
+}
+
+//最近最少使用，访问把key移到队首
+type lruPolicy struct {
+	capacity int
+	ll       *list.List
+	elems    map[interface{}]*list.Element
+}
+
+func newLRUPolicy(capacity int) *lruPolicy {
+	p := &lruPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+func (p *lruPolicy) Add(key interface{}) []interface{} {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+		return nil
+	}
+	p.elems[key] = p.ll.PushFront(key)
+	var evicted []interface{}
+	for p.ll.Len() > p.capacity {
+		back := p.ll.Back()
+		p.ll.Remove(back)
+		delete(p.elems, back.Value)
+		evicted = append(evicted, back.Value)
+	}
+	return evicted
+}
+
+func (p *lruPolicy) Access(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy) Reset() {
+	p.ll = list.New()
+	p.elems = make(map[interface{}]*list.Element)
+}
+
+//先进先出，访问不影响顺序
+type fifoPolicy struct {
+	capacity int
+	ll       *list.List
+	elems    map[interface{}]*list.Element
+}
+
+func newFIFOPolicy(capacity int) *fifoPolicy {
+	p := &fifoPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+func (p *fifoPolicy) Add(key interface{}) []interface{} {
+	if _, ok := p.elems[key]; ok {
+		return nil
+	}
+	p.elems[key] = p.ll.PushFront(key)
+	var evicted []interface{}
+	for p.ll.Len() > p.capacity {
+		back := p.ll.Back()
+		p.ll.Remove(back)
+		delete(p.elems, back.Value)
+		evicted = append(evicted, back.Value)
+	}
+	return evicted
+}
+
+func (p *fifoPolicy) Access(key interface{}) {}
+
+func (p *fifoPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *fifoPolicy) Reset() {
+	p.ll = list.New()
+	p.elems = make(map[interface{}]*list.Element)
+}
+
+//最不经常使用，访问次数相同时淘汰最久没被访问的
+type lfuEntry struct {
+	key   interface{}
+	freq  int
+	tick  uint64 //最后一次访问的逻辑时间
+	index int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+type lfuPolicy struct {
+	capacity int
+	tick     uint64
+	heap     lfuHeap
+	elems    map[interface{}]*lfuEntry
+}
+
+func newLFUPolicy(capacity int) *lfuPolicy {
+	p := &lfuPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+func (p *lfuPolicy) Add(key interface{}) []interface{} {
+	if _, ok := p.elems[key]; ok {
+		p.Access(key)
+		return nil
+	}
+	p.tick++
+	e := &lfuEntry{key: key, freq: 1, tick: p.tick}
+	p.elems[key] = e
+	heap.Push(&p.heap, e)
+	var evicted []interface{}
+	for p.heap.Len() > p.capacity {
+		//新key的频率最低但不能淘汰自己，先取出再放回
+		heap.Remove(&p.heap, e.index)
+		victim := heap.Pop(&p.heap).(*lfuEntry)
+		heap.Push(&p.heap, e)
+		delete(p.elems, victim.key)
+		evicted = append(evicted, victim.key)
+	}
+	return evicted
+}
+
+func (p *lfuPolicy) Access(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.tick++
+		e.freq++
+		e.tick = p.tick
+		heap.Fix(&p.heap, e.index)
+	}
+}
+
+func (p *lfuPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lfuPolicy) Reset() {
+	p.heap = nil
+	p.elems = make(map[interface{}]*lfuEntry)
+}
+
+//自适应替换(Megiddo & Modha)，t1保存只访问过一次的key，t2保存访问过多次的key，
+//b1、b2分别记录从t1、t2淘汰的key(只有key没有数据)，命中b1时增大t1的目标大小p，命中b2时减小p
+type arcEntry struct {
+	key interface{}
+	ll  *list.List //所在的队列
+}
+
+type arcPolicy struct {
+	capacity       int
+	p              int
+	t1, t2, b1, b2 *list.List
+	elems          map[interface{}]*list.Element
+}
+
+func newARCPolicy(capacity int) *arcPolicy {
+	p := &arcPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+func (p *arcPolicy) Add(key interface{}) []interface{} {
+	var evicted []interface{}
+	if elem, ok := p.elems[key]; ok {
+		e := elem.Value.(*arcEntry)
+		switch e.ll {
+		case p.t1, p.t2:
+			p.move(elem, p.t2)
+			return nil
+		case p.b1:
+			p.p = min(p.capacity, p.p+max(p.b2.Len()/p.b1.Len(), 1))
+			evicted = p.replace(false)
+		case p.b2:
+			p.p = max(0, p.p-max(p.b1.Len()/p.b2.Len(), 1))
+			evicted = p.replace(true)
+		}
+		//重新加入的key访问过不止一次
+		p.move(elem, p.t2)
+		return evicted
+	}
+
+	if l1 := p.t1.Len() + p.b1.Len(); l1 >= p.capacity {
+		if p.t1.Len() < p.capacity {
+			p.drop(p.b1)
+			evicted = p.replace(false)
+		} else {
+			//b1为空且t1已满，直接淘汰t1中最旧的key，不进入b1
+			back := p.t1.Back()
+			p.drop(p.t1)
+			evicted = append(evicted, back.Value.(*arcEntry).key)
+		}
+	} else if total := l1 + p.t2.Len() + p.b2.Len(); total >= p.capacity {
+		if total >= 2*p.capacity {
+			p.drop(p.b2)
+		}
+		evicted = p.replace(false)
+	}
+	p.elems[key] = p.t1.PushFront(&arcEntry{key: key, ll: p.t1})
+	return evicted
+}
+
+//缓存已满时从t1或t2淘汰一个key到对应的b1或b2，inB2表示新key命中了b2
+func (p *arcPolicy) replace(inB2 bool) []interface{} {
+	if p.t1.Len()+p.t2.Len() < p.capacity {
+		return nil //之前有key被删除或过期，还有空位
+	}
+	from, to := p.t2, p.b2
+	if p.t1.Len() > 0 && (p.t1.Len() > p.p || (inB2 && p.t1.Len() == p.p) || p.t2.Len() == 0) {
+		from, to = p.t1, p.b1
+	}
+	back := from.Back()
+	p.move(back, to)
+	return []interface{}{back.Value.(*arcEntry).key}
+}
+
+//把key移到ll的队首，key必须已经在某个队列中
+func (p *arcPolicy) move(elem *list.Element, ll *list.List) {
+	e := elem.Value.(*arcEntry)
+	e.ll.Remove(elem)
+	e.ll = ll
+	p.elems[e.key] = ll.PushFront(e)
+}
+
+//删除ll中最旧的key
+func (p *arcPolicy) drop(ll *list.List) {
+	if back := ll.Back(); back != nil {
+		ll.Remove(back)
+		delete(p.elems, back.Value.(*arcEntry).key)
+	}
+}
+
+func (p *arcPolicy) Access(key interface{}) {
+	if elem, ok := p.elems[key]; ok {
+		if e := elem.Value.(*arcEntry); e.ll == p.t1 || e.ll == p.t2 {
+			p.move(elem, p.t2)
+		}
+	}
+}
+
+//被删除或过期的key不进入b1、b2，它们不是因为容量被淘汰的
+func (p *arcPolicy) Remove(key interface{}) {
+	if elem, ok := p.elems[key]; ok {
+		if e := elem.Value.(*arcEntry); e.ll == p.t1 || e.ll == p.t2 {
+			e.ll.Remove(elem)
+			delete(p.elems, key)
+		}
+	}
+}
+
+func (p *arcPolicy) Reset() {
+	p.p = 0
+	p.t1, p.t2, p.b1, p.b2 = list.New(), list.New(), list.New(), list.New()
+	p.elems = make(map[interface{}]*list.Element)
+}
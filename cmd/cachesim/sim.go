@@ -0,0 +1,134 @@
+package main
+
+import (
+	"container/list"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//一组模拟参数
+type config struct {
+	policy   string
+	capacity int
+	ttl      time.Duration //0表示不过期
+}
+
+type result struct {
+	config
+	requests  int
+	hits      int
+	bytes     int64
+	hitBytes  int64
+	evictions int
+	expired   int
+}
+
+func (r result) hitRatio() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.hits) / float64(r.requests)
+}
+
+func (r result) byteHitRatio() float64 {
+	if r.bytes == 0 {
+		return 0
+	}
+	return float64(r.hitBytes) / float64(r.bytes)
+}
+
+//模拟CacheTable的行为：未命中时按Value+loadData的方式写入，命中时像KeepAlive一样刷新过期时间
+//trace中的add也按一次访问处理：key不存在时写入，已存在时刷新
+type simEntry struct {
+	key        string
+	accessedOn time.Time
+	elem       *list.Element
+}
+
+type simCache struct {
+	policy  memory_cache.EvictionPolicy
+	ttl     time.Duration
+	entries map[string]*simEntry
+	recency *list.List //按最后访问时间排序，过期总是从队尾开始
+	res     *result
+}
+
+func simulate(cfg config, reqs []request) (result, error) {
+	policy, err := newPolicy(cfg.policy, cfg.capacity)
+	if err != nil {
+		return result{}, err
+	}
+	res := result{config: cfg}
+	c := &simCache{
+		policy:  policy,
+		ttl:     cfg.ttl,
+		entries: make(map[string]*simEntry),
+		recency: list.New(),
+		res:     &res,
+	}
+	for _, req := range reqs {
+		//写入和删除不计入请求，只改变缓存的内容
+		switch req.op {
+		case opAdd:
+			c.access(req.key, req.time)
+			continue
+		case opDelete:
+			c.expire(req.time)
+			if c.remove(req.key) {
+				c.policy.Remove(req.key)
+			}
+			continue
+		}
+		res.requests++
+		res.bytes += req.size
+		if c.access(req.key, req.time) {
+			res.hits++
+			res.hitBytes += req.size
+		}
+	}
+	return res, nil
+}
+
+func (c *simCache) access(key string, now time.Time) bool {
+	c.expire(now)
+	if e, ok := c.entries[key]; ok {
+		e.accessedOn = now
+		c.recency.MoveToFront(e.elem)
+		c.policy.Access(key)
+		return true
+	}
+	e := &simEntry{key: key, accessedOn: now}
+	e.elem = c.recency.PushFront(e)
+	c.entries[key] = e
+	for _, victim := range c.policy.Add(key) {
+		c.remove(victim.(string))
+		c.res.evictions++
+	}
+	return false
+}
+
+//与expirationCheck一样及时清理过期的key，过期的key不再占用容量
+func (c *simCache) expire(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	for back := c.recency.Back(); back != nil; back = c.recency.Back() {
+		e := back.Value.(*simEntry)
+		if now.Sub(e.accessedOn) <= c.ttl {
+			return
+		}
+		c.remove(e.key)
+		c.policy.Remove(e.key)
+		c.res.expired++
+	}
+}
+
+func (c *simCache) remove(key string) bool {
+	e, ok := c.entries[key]
+	if ok {
+		c.recency.Remove(e.elem)
+		delete(c.entries, key)
+	}
+	return ok
+}
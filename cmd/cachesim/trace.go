@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//一次访问
+type request struct {
+	time time.Time
+	key  string
+	size int64
+	op   string //value、add或delete，空表示value
+}
+
+//trace的格式
+const (
+	formatAuto  = "auto"
+	formatPlain = "plain" //每行一个key
+	formatCSV   = "csv"   //timestamp,key,size，第一行可以是表头，可以读取CacheTable.DumpTrace的输出
+)
+
+//trace中的操作，与memory_cache.TraceOp的名字一致
+const (
+	opValue  = "value"
+	opAdd    = "add"
+	opDelete = "delete"
+)
+
+//读取整个trace，plain格式没有时间戳，按interval为每个请求生成递增的时间
+func readTrace(r io.Reader, format string, interval time.Duration) ([]request, error) {
+	br := bufio.NewReader(r)
+	if format == formatAuto {
+		peek, _ := br.Peek(4096)
+		format = formatPlain
+		if line := strings.SplitN(string(peek), "\n", 2)[0]; strings.Contains(line, ",") {
+			format = formatCSV
+		}
+	}
+	switch format {
+	case formatPlain:
+		return readPlain(br, interval)
+	case formatCSV:
+		return readCSV(br)
+	}
+	return nil, fmt.Errorf("unknown trace format %q", format)
+}
+
+func readPlain(r io.Reader, interval time.Duration) ([]request, error) {
+	var reqs []request
+	now := time.Unix(0, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" {
+			continue
+		}
+		reqs = append(reqs, request{time: now, key: key, size: 1})
+		now = now.Add(interval)
+	}
+	return reqs, scanner.Err()
+}
+
+//CSV的列由表头决定(timestamp/time、key、size、op，不区分大小写)，没有表头时依次为timestamp,key,size
+//只有key是必需的，没有时间列时时间不前进，没有size列时size为1，没有op列时都视为value
+func readCSV(r io.Reader) ([]request, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cols := map[string]int{"timestamp": 0, "key": 1, "size": 2}
+
+	var reqs []request
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return reqs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && isHeader(record) {
+			cols = map[string]int{}
+			for i, name := range record {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "time" {
+					name = "timestamp"
+				}
+				cols[name] = i
+			}
+			if _, ok := cols["key"]; !ok {
+				return nil, fmt.Errorf("csv header has no key column")
+			}
+			continue
+		}
+
+		req := request{size: 1}
+		field := func(name string) (string, bool) {
+			i, ok := cols[name]
+			if !ok || i >= len(record) {
+				return "", false
+			}
+			return strings.TrimSpace(record[i]), true
+		}
+		key, ok := field("key")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: missing key", line)
+		}
+		req.key = key
+		if ts, ok := field("timestamp"); ok && ts != "" {
+			if req.time, err = parseTime(ts); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		if size, ok := field("size"); ok && size != "" {
+			if req.size, err = strconv.ParseInt(size, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid size %q", line, size)
+			}
+		}
+		if op, ok := field("op"); ok {
+			switch op = strings.ToLower(op); op {
+			case "", opValue, opAdd, opDelete:
+				req.op = op
+			default:
+				return nil, fmt.Errorf("line %d: unknown op %q", line, op)
+			}
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+func isHeader(record []string) bool {
+	for _, field := range record {
+		if strings.EqualFold(strings.TrimSpace(field), "key") {
+			return true
+		}
+	}
+	return false
+}
+
+//时间戳可以是Unix秒(允许小数)或RFC3339
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t, nil
+}
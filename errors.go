@@ -5,4 +5,13 @@ import "errors"
 var (
 	ErrNotFound           = errors.New("Key not found in cache")
 	ErrNotFoundOrLoadable = errors.New("Key not found and could not be loaded into cache")
+	ErrKeyIndexDisabled   = errors.New("Key index is not enabled for this table")
+	ErrUnknownPolicy      = errors.New("Unknown eviction policy")
+	ErrTraceDisabled      = errors.New("Access trace is not enabled for this table")
+	ErrHotKeysDisabled    = errors.New("Hot key tracking is not enabled for this table")
+	ErrInvalidRateLimit   = errors.New("Invalid rate limiter options")
+	ErrRateLimited        = errors.New("Rate limit would be exceeded before the context deadline")
+	ErrLocked             = errors.New("Key is locked by another owner")
+	ErrNotLockOwner       = errors.New("Lock is not held by this owner")
+	ErrInvalidLockTTL     = errors.New("Lock ttl must be positive")
 )
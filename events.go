@@ -0,0 +1,153 @@
+package memory_cache
+
+import "sync"
+
+//table事件的订阅
+//事件在修改table的临界区内按发生顺序入队，由单独的goroutine依次分发给监听函数，
+//所以监听函数收到的事件顺序与table实际的修改顺序一致，并且可以在监听函数中任意读写table
+//队列有上限，监听函数处理过慢导致队列写满时丢弃新事件并计数，丢弃的事件不会送达，但仍占用序号，
+//监听方可以通过Seq不连续发现丢失。最后一个订阅者取消后分发goroutine退出
+
+type EventType int
+
+const (
+	EventAdded   EventType = iota + 1 //新增或覆盖添加
+	EventDeleted                      //通过Delete等方法主动删除
+	EventExpired                      //过期被清理
+	EventFlushed                      //整个table被清空
+	EventEvicted                      //容量受限时被淘汰策略移除
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventDeleted:
+		return "deleted"
+	case EventExpired:
+		return "expired"
+	case EventFlushed:
+		return "flushed"
+	case EventEvicted:
+		return "evicted"
+	}
+	return "unknown"
+}
+
+type TableEvent struct {
+	Seq  uint64 //table内单调递增的事件序号
+	Type EventType
+	Key  interface{} //EventFlushed时为nil
+	Item *CacheItem  //EventFlushed时为nil
+}
+
+//等待分发的事件上限
+const maxEventQueue = 64 * 1024
+
+type eventBus struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	seq      uint64
+	queue    []TableEvent
+	inflight int    //分发goroutine取走但还没分发完的事件数，与queue一起计入上限
+	dropped  uint64 //队列写满时丢弃的事件数
+	running  bool   //分发goroutine是否在运行
+	watchers map[uint64]func(event TableEvent)
+	nextID   uint64
+}
+
+//订阅table的事件，返回的cancel用于取消订阅
+//取消订阅时正在分发的一批事件仍可能送达监听函数
+func (table *CacheTable) Watch(f func(event TableEvent)) (cancel func()) {
+	bus := &table.events
+	bus.mu.Lock()
+	if bus.watchers == nil {
+		bus.watchers = make(map[uint64]func(event TableEvent))
+		bus.cond = sync.NewCond(&bus.mu)
+	}
+	if !bus.running {
+		bus.running = true
+		go table.dispatchEvents()
+	}
+	bus.nextID++
+	id := bus.nextID
+	bus.watchers[id] = f
+	bus.mu.Unlock()
+
+	return func() {
+		bus.mu.Lock()
+		delete(bus.watchers, id)
+		if len(bus.watchers) == 0 { //唤醒分发goroutine让它退出
+			bus.cond.Signal()
+		}
+		bus.mu.Unlock()
+	}
+}
+
+//因为队列写满而丢弃的事件数
+func (table *CacheTable) DroppedEvents() uint64 {
+	table.events.mu.Lock()
+	defer table.events.mu.Unlock()
+	return table.events.dropped
+}
+
+//最近一个事件的序号
+func (table *CacheTable) EventSeq() uint64 {
+	table.events.mu.Lock()
+	defer table.events.mu.Unlock()
+	return table.events.seq
+}
+
+//记录一个事件，调用方需持有table的写锁，保证序号与修改顺序一致
+func (table *CacheTable) publish(t EventType, key interface{}, item *CacheItem) {
+	bus := &table.events
+	bus.mu.Lock()
+	bus.seq++
+	if len(bus.watchers) > 0 { //没有订阅者时只推进序号
+		if len(bus.queue)+bus.inflight >= maxEventQueue {
+			bus.dropped++
+			bus.mu.Unlock()
+			return
+		}
+		bus.queue = append(bus.queue, TableEvent{
+			Seq:  bus.seq,
+			Type: t,
+			Key:  key,
+			Item: item,
+		})
+		bus.cond.Signal()
+	}
+	bus.mu.Unlock()
+}
+
+//分发goroutine，在有订阅者时启动，没有订阅者时退出并丢弃剩余的事件
+func (table *CacheTable) dispatchEvents() {
+	bus := &table.events
+	for {
+		bus.mu.Lock()
+		bus.inflight = 0
+		for len(bus.queue) == 0 && len(bus.watchers) > 0 {
+			bus.cond.Wait()
+		}
+		if len(bus.watchers) == 0 {
+			bus.queue = nil
+			bus.running = false
+			bus.mu.Unlock()
+			return
+		}
+		events := bus.queue
+		bus.queue = nil
+		bus.inflight = len(events)
+		watchers := make([]func(event TableEvent), 0, len(bus.watchers))
+		for _, f := range bus.watchers {
+			watchers = append(watchers, f)
+		}
+		bus.mu.Unlock()
+
+		for _, event := range events {
+			for _, f := range watchers {
+				f(event)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//在所有者节点上加载key对应的值
+type Getter func(ctx context.Context, key string) ([]byte, error)
+
+type Options struct {
+	LifeSpan time.Duration //所有者缓存加载结果的生命周期，0表示永久有效
+
+	//从其他节点取到的值以HotRatio的概率镜像到本地热点表，0表示不镜像
+	//访问越频繁的key越容易被镜像，效果上只有热点key会在本地有副本
+	HotRatio      float64
+	HotLifeSpan   time.Duration //热点副本的生命周期，应该较短以控制不一致的时间
+	MaxHotEntries int           //热点表的最大条目数，达到后不再镜像
+}
+
+type Stats struct {
+	Gets        uint64 //Get调用次数
+	Hits        uint64 //本地(包括热点表)命中次数
+	Loads       uint64 //本地Getter实际调用次数
+	PeerLoads   uint64 //从其他节点取值次数
+	PeerErrors  uint64 //从其他节点取值失败次数，失败后会退化为本地加载
+	ServedPeers uint64 //为其他节点提供值的次数
+}
+
+type Group struct {
+	name   string
+	pool   *Pool
+	getter Getter
+	opts   Options
+	main   *memory_cache.CacheTable //本节点作为所有者时缓存的值
+	hot    *memory_cache.CacheTable //其他节点所有的热点key的副本
+	flight flightGroup
+	stats  Stats
+}
+
+var ErrGroupExists = errors.New("group: group already exists")
+
+//在pool上创建Group，所有节点上同名Group的getter应该一致
+func (p *Pool) NewGroup(name string, getter Getter, opts Options) (*Group, error) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.groups[name]; ok {
+		return nil, ErrGroupExists
+	}
+	//表名带上节点地址，同一进程内的多个节点互不影响
+	prefix := p.self + "/" + name
+	g := &Group{
+		name:   name,
+		pool:   p,
+		getter: getter,
+		opts:   opts,
+		main:   memory_cache.Cache(prefix + "/main"),
+		hot:    memory_cache.Cache(prefix + "/hot"),
+	}
+	p.groups[name] = g
+	return g, nil
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:        atomic.LoadUint64(&g.stats.Gets),
+		Hits:        atomic.LoadUint64(&g.stats.Hits),
+		Loads:       atomic.LoadUint64(&g.stats.Loads),
+		PeerLoads:   atomic.LoadUint64(&g.stats.PeerLoads),
+		PeerErrors:  atomic.LoadUint64(&g.stats.PeerErrors),
+		ServedPeers: atomic.LoadUint64(&g.stats.ServedPeers),
+	}
+}
+
+func (g *Group) lookup(key string) ([]byte, bool) {
+	if item, err := g.main.Value(key); err == nil {
+		return item.Data().([]byte), true
+	}
+	if item, err := g.hot.Value(key); err == nil {
+		return item.Data().([]byte), true
+	}
+	return nil, false
+}
+
+//先查本地缓存，未命中时由key的所有者加载
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.Gets, 1)
+	if value, ok := g.lookup(key); ok {
+		atomic.AddUint64(&g.stats.Hits, 1)
+		return value, nil
+	}
+
+	return g.flight.Do(key, func() ([]byte, error) {
+		if value, ok := g.lookup(key); ok { //等待期间可能已被其他调用加载
+			return value, nil
+		}
+		owner := g.pool.owner(key)
+		if owner == g.pool.self {
+			return g.load(ctx, key)
+		}
+		value, err := g.fetch(ctx, owner, key)
+		if err != nil {
+			atomic.AddUint64(&g.stats.PeerErrors, 1)
+			return g.load(ctx, key)
+		}
+		atomic.AddUint64(&g.stats.PeerLoads, 1)
+		g.mirror(key, value)
+		return value, nil
+	})
+}
+
+//为其他节点提供值，不再转发
+func (g *Group) getLocally(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.ServedPeers, 1)
+	if item, err := g.main.Value(key); err == nil {
+		return item.Data().([]byte), nil
+	}
+	return g.flight.Do(key, func() ([]byte, error) {
+		if item, err := g.main.Value(key); err == nil {
+			return item.Data().([]byte), nil
+		}
+		return g.load(ctx, key)
+	})
+}
+
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.Loads, 1)
+	value, err := g.getter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	g.main.Add(key, value, g.opts.LifeSpan)
+	return value, nil
+}
+
+func (g *Group) fetch(ctx context.Context, peer, key string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, peerURL(peer, g.name, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.pool.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("group: fetch %q from %s: %s", key, peer, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (g *Group) mirror(key string, value []byte) {
+	if g.opts.HotRatio <= 0 || rand.Float64() >= g.opts.HotRatio {
+		return
+	}
+	if g.opts.MaxHotEntries > 0 && g.hot.Count() >= g.opts.MaxHotEntries {
+		return
+	}
+	g.hot.Add(key, value, g.opts.HotLifeSpan)
+}
@@ -0,0 +1,205 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//在loopback上启动n个节点，返回各节点的Group和对应的httptest.Server
+func startNodes(t *testing.T, name string, n int, opts Options, getter Getter) ([]*Group, []*httptest.Server) {
+	servers := make([]*httptest.Server, n)
+	pools := make([]*Pool, n)
+	peers := make([]string, n)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		peers[i] = servers[i].URL
+	}
+	groups := make([]*Group, n)
+	for i := range pools {
+		pools[i] = NewPool(peers[i], nil)
+		pools[i].SetPeers(peers...)
+		g, err := pools[i].NewGroup(name, getter, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups[i] = g
+	}
+	return groups, servers
+}
+
+func TestGroupLoadsOnOwner(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	getter := func(ctx context.Context, key string) ([]byte, error) {
+		mu.Lock()
+		loads[key]++
+		mu.Unlock()
+		return []byte("v:" + key), nil
+	}
+	groups, _ := startNodes(t, "owner", 3, Options{}, getter)
+
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint("key", i)
+		for _, g := range groups {
+			value, err := g.Get(ctx, key)
+			if err != nil || string(value) != "v:"+key {
+				t.Fatal("unexpected value", key, string(value), err)
+			}
+		}
+	}
+	for key, n := range loads {
+		if n != 1 {
+			t.Error("key should be loaded once in the cluster", key, n)
+		}
+	}
+
+	var local, peer uint64
+	for _, g := range groups {
+		s := g.Stats()
+		local += s.Loads
+		peer += s.PeerLoads
+	}
+	if local != 30 || peer == 0 {
+		t.Error("unexpected stats", local, peer)
+	}
+}
+
+func TestGroupSingleflight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	getter := func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte(key), nil
+	}
+	groups, _ := startNodes(t, "flight", 2, Options{}, getter)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if value, err := g.Get(context.Background(), "same"); err != nil || string(value) != "same" {
+				t.Error("unexpected value", string(value), err)
+			}
+		}(groups[i%2])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Error("concurrent misses should be merged", calls)
+	}
+}
+
+//加载panic时等待者返回错误，key之后可以再次加载
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		g.Do("k", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("getter failed")
+		})
+	}()
+	<-started
+	waited := make(chan error)
+	go func() {
+		_, err := g.Do("k", func() ([]byte, error) { return nil, nil })
+		waited <- err
+	}()
+	time.Sleep(50 * time.Millisecond) //让第二个调用开始等待
+	close(release)
+	if r := <-panicked; r == nil {
+		t.Error("panic should propagate to the caller running fn")
+	}
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("waiter should see an error after the load panicked")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after the load panicked")
+	}
+	if value, err := g.Do("k", func() ([]byte, error) { return []byte("v"), nil }); err != nil || string(value) != "v" {
+		t.Error("key should load again after a panic", string(value), err)
+	}
+}
+
+func TestGroupHotMirror(t *testing.T) {
+	getter := func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}
+	groups, servers := startNodes(t, "hot", 2, Options{HotRatio: 1, HotLifeSpan: time.Minute, MaxHotEntries: 5}, getter)
+
+	//找一个不属于groups[0]的key
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint("key", i)
+		if groups[0].pool.owner(key) != servers[0].URL {
+			break
+		}
+	}
+	ctx := context.Background()
+	if _, err := groups[0].Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if groups[0].hot.Count() != 1 {
+		t.Fatal("remote value should be mirrored", groups[0].hot.Count())
+	}
+	//所有者下线后仍然可以从热点表读到
+	servers[1].Close()
+	if value, err := groups[0].Get(ctx, key); err != nil || string(value) != key {
+		t.Error("hot key should be served locally", string(value), err)
+	}
+	if s := groups[0].Stats(); s.PeerLoads != 1 || s.Hits != 1 {
+		t.Error("unexpected stats", s)
+	}
+}
+
+func TestGroupPeerFailure(t *testing.T) {
+	errLoad := errors.New("load failed")
+	getter := func(ctx context.Context, key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, errLoad
+		}
+		return []byte(key), nil
+	}
+	groups, servers := startNodes(t, "fail", 2, Options{}, getter)
+	ctx := context.Background()
+	if _, err := groups[0].Get(ctx, "bad"); err == nil {
+		t.Error("loader error should be returned")
+	}
+
+	//所有者不可用时退化为本地加载
+	servers[1].Close()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("key", i)
+		if value, err := groups[0].Get(ctx, key); err != nil || string(value) != key {
+			t.Fatal("should fall back to local load", key, err)
+		}
+	}
+	if groups[0].Stats().PeerErrors == 0 {
+		t.Error("peer errors should be counted")
+	}
+
+	if _, err := groups[0].pool.NewGroup("fail", getter, Options{}); err != ErrGroupExists {
+		t.Error("duplicate group should be rejected", err)
+	}
+}
@@ -0,0 +1,110 @@
+//Package group 实现groupcache风格的分布式加载。
+//
+//每个节点运行一个Pool，所有节点的Pool用同样的成员列表组成一致性哈希环，每个key只有一个所有者。
+//Group.Get在本地未命中时，非所有者通过HTTP向所有者请求，所有者用singleflight合并并发请求后调用Getter加载，
+//因此同一个key在整个集群中只会被加载一次。从其他节点取到的值可以按一定比例镜像到本地的热点表中，
+//被频繁访问的key很快就会在各个节点上都有副本，不再集中访问所有者。
+package group
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/TonyXMH/MemoryCache/cluster"
+)
+
+const defaultBasePath = "/_group/"
+
+//一个节点上所有Group共享的成员信息，同时作为处理其他节点请求的http.Handler
+type Pool struct {
+	sync.RWMutex
+
+	self   string //本节点的base URL，必须和其他节点SetPeers时使用的一致
+	ring   *cluster.Ring
+	client *http.Client
+	groups map[string]*Group
+}
+
+//self为本节点的base URL，如http://10.0.0.1:8000
+func NewPool(self string, client *http.Client) *Pool {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p := &Pool{
+		self:   strings.TrimSuffix(self, "/"),
+		ring:   cluster.NewRing(cluster.DefaultReplicas),
+		client: client,
+		groups: make(map[string]*Group),
+	}
+	p.ring.Add(p.self, 1)
+	return p
+}
+
+//设置集群的全部成员(包括本节点)，成员为各节点的base URL
+func (p *Pool) SetPeers(peers ...string) {
+	ring := cluster.NewRing(cluster.DefaultReplicas)
+	for _, peer := range peers {
+		ring.Add(strings.TrimSuffix(peer, "/"), 1)
+	}
+	p.Lock()
+	p.ring = ring
+	p.Unlock()
+}
+
+//key的所有者
+func (p *Pool) owner(key string) string {
+	p.RLock()
+	ring := p.ring
+	p.RUnlock()
+	owner, ok := ring.Get(key)
+	if !ok {
+		return p.self
+	}
+	return owner
+}
+
+func (p *Pool) group(name string) (*Group, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	g, ok := p.groups[name]
+	return g, ok
+}
+
+//处理其他节点的请求：GET /_group/{group}/{key}
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, defaultBasePath) || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, defaultBasePath), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	name, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	g, ok := p.group(name)
+	if !ok {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	//其他节点发来的请求总是在本地加载，避免成员列表不一致时来回转发
+	value, err := g.getLocally(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+func peerURL(peer, group, key string) string {
+	return peer + defaultBasePath + url.PathEscape(group) + "/" + url.PathEscape(key)
+}
@@ -0,0 +1,47 @@
+package group
+
+import (
+	"errors"
+	"sync"
+)
+
+var errLoadPanicked = errors.New("group: load panicked")
+
+//合并同一个key的并发加载，同一时刻只有一个调用真正执行
+type call struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func (g *flightGroup) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	//fn panic时等待者也要被唤醒，key之后还能再次加载
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.err = errLoadPanicked //fn正常返回时被覆盖
+	c.value, c.err = fn()
+	return c.value, c.err
+}
@@ -0,0 +1,248 @@
+package memory_cache
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//热点key统计：带指数衰减的space-saving算法
+//只跟踪固定数量的key，计数按半衰期衰减，反映的是最近一段时间的请求速率，而不是MostAccessed那样的累计访问次数
+//
+//衰减使用forward decay：t时刻的一次访问计为exp(λ(t-L))，L为基准时间，
+//所有计数按同一比例衰减，不需要定时更新每个计数，排序也不受影响
+//
+//与淘汰策略的accesses一样，Value只把访问放入有损的缓冲，缓冲满时抢到锁的goroutine批量处理，
+//抢不到锁时丢弃这次访问，读取路径不会在tracker的锁上排队；OnHot因此最多延迟一个缓冲的访问量
+
+const (
+	DefaultHotKeyCapacity = 1024
+	DefaultHotKeyHalfLife = 10 * time.Second
+)
+
+type HotKeyOptions struct {
+	Capacity  int                                 //最多跟踪的key数，越大越精确，<=0时使用DefaultHotKeyCapacity
+	HalfLife  time.Duration                       //计数的半衰期，<=0时使用DefaultHotKeyHalfLife
+	Threshold float64                             //每秒请求数，key的速率从低于Threshold升到不低于Threshold时调用OnHot
+	OnHot     func(key interface{}, rate float64) //在处理缓冲的Value或HotKeys调用中、所有锁之外同步执行
+}
+
+type HotKey struct {
+	Key   interface{}
+	Rate  float64 //估计的每秒请求数，可能偏高
+	Error float64 //Rate最多偏高的值，Rate-Error是速率的下界
+}
+
+type hotCounter struct {
+	key   interface{}
+	count float64 //forward decay下的计数
+	err   float64 //替换进来时继承的计数
+	hot   bool
+	index int
+}
+
+type hotCounterHeap []*hotCounter
+
+func (h hotCounterHeap) Len() int           { return len(h) }
+func (h hotCounterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotCounterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hotCounterHeap) Push(x interface{}) {
+	c := x.(*hotCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *hotCounterHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+//Value与tracker之间的访问缓冲大小
+const hotKeyBufferSize = 256
+
+type hotAccess struct {
+	key interface{}
+	at  time.Time
+}
+
+type hotKeyTracker struct {
+	opts     HotKeyOptions
+	lambda   float64 //每秒的衰减率
+	accesses chan hotAccess
+
+	mu       sync.Mutex
+	landmark time.Time
+	counters map[interface{}]*hotCounter
+	heap     hotCounterHeap //按count的小根堆，堆顶是替换的候选
+}
+
+//超过该指数后把所有计数按比例缩小并移动基准时间，避免浮点溢出
+const hotKeyMaxExponent = 64
+
+func newHotKeyTracker(opts HotKeyOptions, now time.Time) *hotKeyTracker {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultHotKeyCapacity
+	}
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = DefaultHotKeyHalfLife
+	}
+	return &hotKeyTracker{
+		opts:     opts,
+		lambda:   math.Ln2 / opts.HalfLife.Seconds(),
+		accesses: make(chan hotAccess, hotKeyBufferSize),
+		landmark: now,
+		counters: make(map[interface{}]*hotCounter, opts.Capacity),
+	}
+}
+
+//开启热点key统计，统计的是Value的调用(包括未命中)，已经开启时按新的参数重新开始统计
+func (table *CacheTable) EnableHotKeys(opts HotKeyOptions) {
+	tracker := newHotKeyTracker(opts, time.Now())
+	table.Lock()
+	table.hotKeys = tracker
+	table.Unlock()
+}
+
+func (table *CacheTable) DisableHotKeys() {
+	table.Lock()
+	table.hotKeys = nil
+	table.Unlock()
+}
+
+//按请求速率从高到低返回前k个key，k<=0表示返回所有跟踪中的key
+func (table *CacheTable) HotKeys(k int) ([]HotKey, error) {
+	table.RLock()
+	tracker := table.hotKeys
+	table.RUnlock()
+	if tracker == nil {
+		return nil, ErrHotKeysDisabled
+	}
+	return tracker.top(k, time.Now()), nil
+}
+
+//记录一次请求，tracker为nil时什么也不做
+func (tracker *hotKeyTracker) record(key interface{}, now time.Time) {
+	if tracker == nil {
+		return
+	}
+	access := hotAccess{key: key, at: now}
+	select {
+	case tracker.accesses <- access:
+		return
+	default:
+	}
+	//缓冲满了，由抢到锁的goroutine清空缓冲，其他goroutine直接丢弃这次访问
+	if !tracker.mu.TryLock() {
+		return
+	}
+	hot := tracker.drainLocked()
+	hot = tracker.processLocked(access, hot)
+	tracker.mu.Unlock()
+	tracker.fire(hot)
+}
+
+//处理缓冲中的所有访问，调用方需持有tracker.mu，返回需要触发OnHot的key
+func (tracker *hotKeyTracker) drainLocked() []HotKey {
+	var hot []HotKey
+	for {
+		select {
+		case access := <-tracker.accesses:
+			hot = tracker.processLocked(access, hot)
+		default:
+			return hot
+		}
+	}
+}
+
+func (tracker *hotKeyTracker) fire(hot []HotKey) {
+	for _, h := range hot {
+		tracker.opts.OnHot(h.Key, h.Rate)
+	}
+}
+
+func (tracker *hotKeyTracker) processLocked(access hotAccess, hot []HotKey) []HotKey {
+	key, now := access.key, access.at
+	exponent := tracker.lambda * now.Sub(tracker.landmark).Seconds()
+	if exponent > hotKeyMaxExponent {
+		tracker.rescale(exponent, now)
+		exponent = 0
+	}
+	weight := math.Exp(exponent)
+
+	c, ok := tracker.counters[key]
+	switch {
+	case ok:
+		c.count += weight
+		heap.Fix(&tracker.heap, c.index)
+	case len(tracker.heap) < tracker.opts.Capacity:
+		c = &hotCounter{key: key, count: weight}
+		tracker.counters[key] = c
+		heap.Push(&tracker.heap, c)
+	default:
+		//替换计数最小的key，新key继承它的计数作为误差上界
+		c = tracker.heap[0]
+		delete(tracker.counters, c.key)
+		c.key = key
+		c.err = c.count
+		c.count += weight
+		c.hot = false
+		tracker.counters[key] = c
+		heap.Fix(&tracker.heap, 0)
+	}
+
+	if tracker.opts.OnHot != nil && tracker.opts.Threshold > 0 {
+		//用速率的下界判断，刚替换进来的key不会因为继承的计数误报
+		rate := tracker.rate(c.count-c.err, exponent)
+		if rate >= tracker.opts.Threshold && !c.hot {
+			hot = append(hot, HotKey{Key: key, Rate: rate})
+		}
+		c.hot = rate >= tracker.opts.Threshold
+	}
+	return hot
+}
+
+//把forward decay下的计数换算成每秒请求数，exponent为当前时刻相对基准时间的指数
+//以速率r持续访问时衰减计数趋近于r/λ
+func (tracker *hotKeyTracker) rate(count, exponent float64) float64 {
+	return count / math.Exp(exponent) * tracker.lambda
+}
+
+func (tracker *hotKeyTracker) rescale(exponent float64, now time.Time) {
+	factor := math.Exp(-exponent)
+	for _, c := range tracker.heap {
+		c.count *= factor
+		c.err *= factor
+	}
+	tracker.landmark = now
+}
+
+func (tracker *hotKeyTracker) top(k int, now time.Time) []HotKey {
+	tracker.mu.Lock()
+	hot := tracker.drainLocked()
+	exponent := tracker.lambda * now.Sub(tracker.landmark).Seconds()
+	keys := make([]HotKey, 0, len(tracker.heap))
+	for _, c := range tracker.heap {
+		keys = append(keys, HotKey{
+			Key:   c.key,
+			Rate:  tracker.rate(c.count, exponent),
+			Error: tracker.rate(c.err, exponent),
+		})
+	}
+	tracker.mu.Unlock()
+	tracker.fire(hot)
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Rate > keys[j].Rate
+	})
+	if k > 0 && k < len(keys) {
+		keys = keys[:k]
+	}
+	return keys
+}
@@ -0,0 +1,247 @@
+//Package httpapi 以HTTP/JSON的方式暴露Cache(name)注册的所有表，方便用curl查看和操作缓存。
+//
+//	GET    /tables                        所有表的统计信息
+//	GET    /tables/{name}/stats           表的统计信息
+//	POST   /tables/{name}/flush           清空表
+//	GET    /tables/{name}/top?n=10        访问次数最多的n个item
+//	GET    /tables/{name}/items/{key}     查看item，不会更新访问信息
+//	PUT    /tables/{name}/items/{key}     写入item，请求体为JSON编码的值
+//	DELETE /tables/{name}/items/{key}     删除item
+//
+//PUT的生命周期通过查询参数ttl或请求头X-Cache-TTL指定，可以是Go的时间格式(如30s)或整数秒，
+//不指定时使用表的默认生命周期。key中包含/时需要编码为%2F。
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const (
+	TTLHeader    = "X-Cache-TTL"
+	defaultTopN  = 10
+	maxValueSize = 1 << 20 //PUT请求体的上限，超过时返回413
+)
+
+type Handler struct{}
+
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+//item的JSON表示
+type Item struct {
+	Key           interface{} `json:"key"`
+	Value         interface{} `json:"value"`
+	LifeSpan      string      `json:"lifeSpan"`
+	CreatedOn     time.Time   `json:"createdOn"`
+	AccessedOn    time.Time   `json:"accessedOn"`
+	AccessedCount int64       `json:"accessedCount"`
+	ExpiresAt     *time.Time  `json:"expiresAt,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+}
+
+func newItem(item *memory_cache.CacheItem) Item {
+	value := item.Data()
+	if b, ok := value.([]byte); ok && utf8.Valid(b) { //RESP等前端写入的字节串按字符串展示
+		value = string(b)
+	}
+	if _, err := json.Marshal(value); err != nil { //无法JSON编码的值退化为字符串
+		value = fmt.Sprint(value)
+	}
+	it := Item{
+		Key:           item.Key(),
+		Value:         value,
+		LifeSpan:      item.LifeSpan().String(),
+		CreatedOn:     item.CreatedOn(),
+		AccessedOn:    item.AccessedOn(),
+		AccessedCount: item.AccessedCount(),
+		Tags:          item.Tags(),
+	}
+	if expiresAt := item.ExpiresAt(); !expiresAt.IsZero() {
+		it.ExpiresAt = &expiresAt
+	}
+	return it
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//按转义前的路径切分，保证key中编码过的/不会被当成分隔符
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid path")
+			return
+		}
+		parts[i] = unescaped
+	}
+	if parts[0] != "tables" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		h.allow(w, r, http.MethodGet, h.listTables)
+	case len(parts) == 3 && parts[2] == "stats":
+		h.withTable(w, r, parts[1], http.MethodGet, h.tableStats)
+	case len(parts) == 3 && parts[2] == "flush":
+		h.withTable(w, r, parts[1], http.MethodPost, h.flush)
+	case len(parts) == 3 && parts[2] == "top":
+		h.withTable(w, r, parts[1], http.MethodGet, h.top)
+	case len(parts) == 4 && parts[2] == "items":
+		h.item(w, r, parts[1], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, method string, f func(w http.ResponseWriter, r *http.Request)) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	f(w, r)
+}
+
+//只操作已经存在的表，避免GET请求在注册表中创建空表
+func (h *Handler) withTable(w http.ResponseWriter, r *http.Request, name, method string, f func(w http.ResponseWriter, r *http.Request, table *memory_cache.CacheTable)) {
+	h.allow(w, r, method, func(w http.ResponseWriter, r *http.Request) {
+		table, ok := memory_cache.LookupCache(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "table not found")
+			return
+		}
+		f(w, r, table)
+	})
+}
+
+func (h *Handler) listTables(w http.ResponseWriter, r *http.Request) {
+	tables := []memory_cache.TableStats{}
+	for _, name := range memory_cache.Tables() {
+		if table, ok := memory_cache.LookupCache(name); ok {
+			tables = append(tables, table.Stats())
+		}
+	}
+	writeJSON(w, http.StatusOK, tables)
+}
+
+func (h *Handler) tableStats(w http.ResponseWriter, r *http.Request, table *memory_cache.CacheTable) {
+	writeJSON(w, http.StatusOK, table.Stats())
+}
+
+func (h *Handler) flush(w http.ResponseWriter, r *http.Request, table *memory_cache.CacheTable) {
+	table.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) top(w http.ResponseWriter, r *http.Request, table *memory_cache.CacheTable) {
+	n := defaultTopN
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "n must be a positive integer")
+			return
+		}
+	}
+	items := []Item{}
+	for _, item := range table.MostAccessed(n) {
+		items = append(items, newItem(item))
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) item(w http.ResponseWriter, r *http.Request, name, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		table, ok := memory_cache.LookupCache(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "table not found")
+			return
+		}
+		item, err := table.Peek(key)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, newItem(item))
+	case http.MethodPut:
+		lifeSpan, err := parseTTL(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var value interface{}
+		if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValueSize)).Decode(&value); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid JSON value: "+err.Error())
+			return
+		}
+		item, err := memory_cache.Cache(name).Put(key, value, lifeSpan)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, newItem(item))
+	case http.MethodDelete:
+		table, ok := memory_cache.LookupCache(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "table not found")
+			return
+		}
+		if _, err := table.Delete(key); err == memory_cache.ErrNotFound {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil { //write-through失败
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+//查询参数优先于请求头，都没有时使用表的默认生命周期
+func parseTTL(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("ttl")
+	if s == "" {
+		s = r.Header.Get(TTLHeader)
+	}
+	if s == "" {
+		return memory_cache.DefaultExpiration, nil
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ttl %q", s)
+	}
+	return d, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func do(t *testing.T, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	NewHandler().ServeHTTP(w, r)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+}
+
+func TestItems(t *testing.T) {
+	if table, ok := memory_cache.LookupCache("TestItems"); ok {
+		table.Flush()
+	}
+	w := do(t, "PUT", "/tables/TestItems/items/user%2F1?ttl=30s", `{"name":"tony","age":3}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatal("PUT failed", w.Code, w.Body.String())
+	}
+	table, _ := memory_cache.LookupCache("TestItems")
+	item, err := table.Peek("user/1")
+	if err != nil || item.LifeSpan() != 30*time.Second {
+		t.Fatal("PUT should store item with ttl from query", err)
+	}
+
+	var got Item
+	w = do(t, "GET", "/tables/TestItems/items/user%2F1", "", nil)
+	decode(t, w, &got)
+	if w.Code != http.StatusOK || got.Key != "user/1" || got.Value.(map[string]interface{})["name"] != "tony" || got.ExpiresAt == nil {
+		t.Error("GET returned wrong item", w.Body.String())
+	}
+	if item.AccessedCount() != 0 {
+		t.Error("GET should not update access info")
+	}
+
+	do(t, "PUT", "/tables/TestItems/items/k", `"v"`, map[string]string{TTLHeader: "60"})
+	if item, _ = table.Peek("k"); item.LifeSpan() != time.Minute || item.Data() != "v" {
+		t.Error("PUT should read ttl from header")
+	}
+	if w = do(t, "PUT", "/tables/TestItems/items/k", `{bad`, nil); w.Code != http.StatusBadRequest {
+		t.Error("PUT with invalid JSON should fail", w.Code)
+	}
+	if w = do(t, "PUT", "/tables/TestItems/items/k?ttl=soon", `1`, nil); w.Code != http.StatusBadRequest {
+		t.Error("PUT with invalid ttl should fail", w.Code)
+	}
+
+	if w = do(t, "DELETE", "/tables/TestItems/items/k", "", nil); w.Code != http.StatusNoContent {
+		t.Error("DELETE failed", w.Code)
+	}
+	if w = do(t, "DELETE", "/tables/TestItems/items/k", "", nil); w.Code != http.StatusNotFound {
+		t.Error("DELETE missing item should return 404", w.Code)
+	}
+	if w = do(t, "GET", "/tables/TestItems/items/k", "", nil); w.Code != http.StatusNotFound {
+		t.Error("GET missing item should return 404", w.Code)
+	}
+	if w = do(t, "POST", "/tables/TestItems/items/k", "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Error("POST item should not be allowed", w.Code)
+	}
+
+	big := `"` + strings.Repeat("x", maxValueSize) + `"`
+	if w = do(t, "PUT", "/tables/TestItems/items/big", big, nil); w.Code != http.StatusRequestEntityTooLarge || table.Exists("big") {
+		t.Error("PUT with a body over the limit should fail", w.Code)
+	}
+
+	//write-through删除失败不是404
+	do(t, "PUT", "/tables/TestItems/items/k", `1`, nil)
+	table.SetWriteThrough(failingWriter{})
+	defer table.SetWriteThrough(nil)
+	if w = do(t, "DELETE", "/tables/TestItems/items/k", "", nil); w.Code != http.StatusInternalServerError || !table.Exists("k") {
+		t.Error("DELETE should report write-through failures", w.Code)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(ops []memory_cache.WriteOp) error {
+	return errors.New("backend down")
+}
+
+func TestTables(t *testing.T) {
+	table := memory_cache.Cache("TestTables")
+	table.Flush()
+	before := table.Stats() //Flush不会清零命中计数
+	for i := 0; i < 5; i++ {
+		table.Add(i, []byte("v"), 0)
+		for j := 0; j < i; j++ {
+			table.Value(i)
+		}
+	}
+	table.Value("missing")
+
+	var tables []memory_cache.TableStats
+	decode(t, do(t, "GET", "/tables", "", nil), &tables)
+	found := false
+	for _, stats := range tables {
+		found = found || stats.Name == "TestTables" && stats.Count == 5
+	}
+	if !found {
+		t.Error("GET /tables should list registered tables", tables)
+	}
+
+	var stats memory_cache.TableStats
+	decode(t, do(t, "GET", "/tables/TestTables/stats", "", nil), &stats)
+	if stats.Count != 5 || stats.Hits != before.Hits+10 || stats.Misses != before.Misses+1 {
+		t.Error("wrong table stats", stats)
+	}
+
+	var top []Item
+	decode(t, do(t, "GET", "/tables/TestTables/top?n=2", "", nil), &top)
+	if len(top) != 2 || top[0].Key != float64(4) || top[0].Value != "v" || top[1].AccessedCount != 3 {
+		t.Error("wrong top items", top)
+	}
+	if w := do(t, "GET", "/tables/TestTables/top?n=-1", "", nil); w.Code != http.StatusBadRequest {
+		t.Error("invalid n should fail", w.Code)
+	}
+
+	if w := do(t, "GET", "/tables/TestTables/flush", "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Error("flush should require POST", w.Code)
+	}
+	if w := do(t, "POST", "/tables/TestTables/flush", "", nil); w.Code != http.StatusNoContent || table.Count() != 0 {
+		t.Error("flush failed", w.Code)
+	}
+
+	if w := do(t, "GET", "/tables/NoSuchTable/stats", "", nil); w.Code != http.StatusNotFound {
+		t.Error("unknown table should return 404", w.Code)
+	}
+	if _, ok := memory_cache.LookupCache("NoSuchTable"); ok {
+		t.Error("GET should not create tables")
+	}
+	if w := do(t, "GET", "/other", "", nil); w.Code != http.StatusNotFound {
+		t.Error("unknown path should return 404", w.Code)
+	}
+}
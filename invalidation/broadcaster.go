@@ -0,0 +1,92 @@
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//把本地CacheTable接入Transport，只支持string类型的key
+type Broadcaster struct {
+	origin    string
+	table     *memory_cache.CacheTable
+	transport Transport
+	cancel    func()
+}
+
+//origin为空时随机生成，同一个Transport上可以挂多个不同表的Broadcaster
+func NewBroadcaster(table *memory_cache.CacheTable, transport Transport, origin string) *Broadcaster {
+	if origin == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		origin = hex.EncodeToString(buf)
+	}
+	b := &Broadcaster{
+		origin:    origin,
+		table:     table,
+		transport: transport,
+	}
+	b.cancel = transport.Subscribe(b.apply)
+	return b
+}
+
+func (b *Broadcaster) Origin() string {
+	return b.origin
+}
+
+//把收到的消息作用于本地表，自己发出的和其他表的消息直接忽略
+func (b *Broadcaster) apply(msg Message) {
+	if msg.Origin == b.origin || msg.Table != b.table.Name() {
+		return
+	}
+	switch msg.Op {
+	case OpDelete:
+		b.table.Delete(msg.Key)
+	case OpFlush:
+		b.table.Flush()
+	case OpInvalidateTag:
+		b.table.InvalidateTag(msg.Tag)
+	}
+}
+
+func (b *Broadcaster) publish(msg Message) error {
+	msg.Origin = b.origin
+	msg.Table = b.table.Name()
+	if err := b.transport.Publish(msg); err != nil {
+		return fmt.Errorf("invalidation: publish %s: %v", msg.Op, err)
+	}
+	return nil
+}
+
+//写入本地表，并让其他副本删除各自的旧值
+func (b *Broadcaster) Set(key string, data interface{}, lifeSpan time.Duration) (*memory_cache.CacheItem, error) {
+	item, err := b.table.Put(key, data, lifeSpan)
+	if err != nil {
+		return nil, err
+	}
+	return item, b.publish(Message{Op: OpDelete, Key: key})
+}
+
+//删除本地的key，不论本地是否存在都会通知其他副本
+func (b *Broadcaster) Delete(key string) error {
+	b.table.Delete(key)
+	return b.publish(Message{Op: OpDelete, Key: key})
+}
+
+func (b *Broadcaster) Flush() error {
+	b.table.Flush()
+	return b.publish(Message{Op: OpFlush})
+}
+
+func (b *Broadcaster) InvalidateTag(tag string) (int, error) {
+	n := b.table.InvalidateTag(tag)
+	return n, b.publish(Message{Op: OpInvalidateTag, Tag: tag})
+}
+
+//停止接收消息，不会关闭Transport
+func (b *Broadcaster) Close() {
+	b.cancel()
+}
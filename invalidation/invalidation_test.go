@@ -0,0 +1,232 @@
+package invalidation
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//同名表在同一进程内是同一个实例，测试用带副本编号的表名模拟多个进程，再把消息里的表名对齐
+type renameTransport struct {
+	Transport
+	name string
+}
+
+func (t renameTransport) Subscribe(f func(msg Message)) (cancel func()) {
+	return t.Transport.Subscribe(func(msg Message) {
+		msg.Table = t.name
+		f(msg)
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testReplicas(t *testing.T, name string, transports []Transport) {
+	bs := make([]*Broadcaster, len(transports))
+	tables := make([]*memory_cache.CacheTable, len(transports))
+	for i, tr := range transports {
+		tables[i] = memory_cache.Cache(name + string(rune('a'+i)))
+		bs[i] = NewBroadcaster(tables[i], renameTransport{tr, tables[i].Name()}, "")
+		defer bs[i].Close()
+	}
+	for _, table := range tables {
+		table.Add("k1", 1, 0)
+		table.Add("k2", 2, 0)
+		table.AddWithTags("frag1", "x", 0, "user:1")
+		table.AddWithTags("frag2", "y", 0, "user:1")
+		table.Add("other", 3, 0)
+	}
+
+	if _, err := bs[0].Set("k1", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := tables[0].Value("k1"); err != nil || item.Data() != 10 {
+		t.Error("local write lost", err)
+	}
+	for _, table := range tables[1:] {
+		waitFor(t, func() bool { return !table.Exists("k1") })
+	}
+
+	if err := bs[1].Delete("k2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		waitFor(t, func() bool { return !table.Exists("k2") })
+	}
+
+	if n, err := bs[2].InvalidateTag("user:1"); err != nil || n != 2 {
+		t.Fatal("unexpected local invalidation", n, err)
+	}
+	for _, table := range tables {
+		waitFor(t, func() bool { return len(table.KeysByTag("user:1")) == 0 })
+		if !table.Exists("other") {
+			t.Error("untagged key should survive")
+		}
+	}
+
+	if err := bs[0].Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		waitFor(t, func() bool { return table.Count() == 0 })
+	}
+}
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	testReplicas(t, "invalidation_mem_", []Transport{bus.Join(), bus.Join(), bus.Join()})
+}
+
+func TestNoEcho(t *testing.T) {
+	bus := NewMemoryBus()
+	local := memory_cache.Cache("invalidation_echo")
+	b := NewBroadcaster(local, bus.Join(), "self")
+	defer b.Close()
+
+	//自己发出的消息不能作用于本地表
+	local.Add("k", 1, 0)
+	if err := bus.Join().Publish(Message{Origin: "self", Table: local.Name(), Op: OpDelete, Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if !local.Exists("k") {
+		t.Error("own message should be ignored")
+	}
+	//其他表的消息也不能作用于本地表
+	bus.Join().Publish(Message{Origin: "other", Table: "another_table", Op: OpFlush})
+	if !local.Exists("k") {
+		t.Error("message for another table should be ignored")
+	}
+	bus.Join().Publish(Message{Origin: "other", Table: local.Name(), Op: OpDelete, Key: "k"})
+	if local.Exists("k") {
+		t.Error("remote delete should be applied")
+	}
+
+	tr := bus.Join()
+	tr.Close()
+	if err := tr.Publish(Message{}); err != ErrClosed {
+		t.Error("publish on closed transport should fail", err)
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	var trs []*UDPTransport
+	for i := 0; i < 3; i++ {
+		tr, err := ListenUDP("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.Close()
+		trs = append(trs, tr)
+	}
+	var peers []string
+	for _, tr := range trs {
+		peers = append(peers, tr.Addr().String())
+	}
+	transports := make([]Transport, len(trs))
+	for i, tr := range trs {
+		tr.SetPeers(peers...)
+		transports[i] = tr
+	}
+	testReplicas(t, "invalidation_udp_", transports)
+}
+
+func TestTCPTransport(t *testing.T) {
+	var trs []*TCPTransport
+	for i := 0; i < 3; i++ {
+		tr, err := ListenTCP("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.Close()
+		trs = append(trs, tr)
+	}
+	transports := make([]Transport, len(trs))
+	for i, tr := range trs {
+		var peers []string
+		for j, other := range trs {
+			if i != j {
+				peers = append(peers, other.Addr().String())
+			}
+		}
+		tr.SetPeers(peers...)
+		transports[i] = tr
+	}
+	testReplicas(t, "invalidation_tcp_", transports)
+
+}
+
+func TestTCPPublishDialOutsideLock(t *testing.T) {
+	tr, err := ListenTCP("127.0.0.1:0", "blocked:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	tr.dial = func(addr string, timeout time.Duration) (net.Conn, error) {
+		close(dialing)
+		<-release
+		return nil, errors.New("unreachable")
+	}
+	published := make(chan error, 1)
+	go func() {
+		published <- tr.Publish(Message{})
+	}()
+	<-dialing
+
+	//连不上的peer不阻塞其他操作
+	done := make(chan struct{})
+	go func() {
+		tr.SetPeers()
+		tr.Subscribe(func(Message) {})()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SetPeers blocked behind a dial")
+	}
+	close(release)
+	if err := <-published; err == nil {
+		t.Error("publish to an unreachable peer should fail")
+	}
+}
+
+func TestUDPReadErrorBackoff(t *testing.T) {
+	tr, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//向没有监听的端口发送，在部分系统上之后的读取会因ICMP不可达返回错误，readLoop应退避而不是空转
+	closed, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := closed.Addr().String()
+	closed.Close()
+	tr.SetPeers(addr)
+	for i := 0; i < 10; i++ {
+		tr.Publish(Message{})
+	}
+	start := time.Now()
+	tr.Close()
+	if time.Since(start) > time.Second {
+		t.Error("Close should not wait for the read backoff")
+	}
+	select {
+	case <-tr.done:
+	default:
+		t.Error("readLoop should exit after Close")
+	}
+}
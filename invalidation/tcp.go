@@ -0,0 +1,232 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+//每个副本监听一个TCP端口，发送时逐个写入到所有peer的长连接(扇出)
+//连接按需建立，写失败后关闭，下次发送时重连
+//建立连接和写入只持有该peer自己的锁，一个peer连不上不会阻塞SetPeers、Close和接收
+type TCPTransport struct {
+	ln   net.Listener
+	subs subscribers
+
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	mu     sync.Mutex
+	peers  []string
+	out    map[string]*tcpPeer
+	in     map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+
+	dial func(addr string, timeout time.Duration) (net.Conn, error) //测试时替换
+}
+
+type tcpPeer struct {
+	addr string
+	mu   sync.Mutex //保证同一个peer的写入按顺序进行
+
+	connMu sync.Mutex //只在读写conn时短暂持有，close不需要等待正在进行的连接和写入
+	conn   net.Conn
+	enc    *json.Encoder
+	closed bool
+}
+
+func ListenTCP(addr string, peers ...string) (*TCPTransport, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{
+		ln:           ln,
+		DialTimeout:  time.Second,
+		WriteTimeout: time.Second,
+		peers:        peers,
+		out:          make(map[string]*tcpPeer),
+		in:           make(map[net.Conn]struct{}),
+		dial:         dialTCP,
+	}
+	t.wg.Add(1)
+	go t.acceptLoop()
+	return t, nil
+}
+
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+func (t *TCPTransport) Addr() net.Addr {
+	return t.ln.Addr()
+}
+
+//替换peer列表，不在新列表中的连接会被关闭
+func (t *TCPTransport) SetPeers(peers ...string) {
+	t.mu.Lock()
+	keep := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		keep[peer] = true
+	}
+	var removed []*tcpPeer
+	for addr, p := range t.out {
+		if !keep[addr] {
+			removed = append(removed, p)
+			delete(t.out, addr)
+		}
+	}
+	t.peers = peers
+	t.mu.Unlock()
+	for _, p := range removed {
+		p.close()
+	}
+}
+
+//按顺序写入所有peer，返回遇到的第一个错误，单个peer失败不影响其他peer
+func (t *TCPTransport) Publish(msg Message) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrClosed
+	}
+	peers := make([]*tcpPeer, 0, len(t.peers))
+	for _, addr := range t.peers {
+		p, ok := t.out[addr]
+		if !ok {
+			p = &tcpPeer{addr: addr}
+			t.out[addr] = p
+		}
+		peers = append(peers, p)
+	}
+	dialer := tcpDialer{dial: t.dial, dialTimeout: t.DialTimeout, writeTimeout: t.WriteTimeout}
+	t.mu.Unlock()
+
+	var firstErr error
+	for _, p := range peers {
+		if err := p.send(msg, dialer); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type tcpDialer struct {
+	dial         func(addr string, timeout time.Duration) (net.Conn, error)
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+//连接断开时在这里重连
+func (p *tcpPeer) send(msg Message, dialer tcpDialer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connMu.Lock()
+	conn, enc, closed := p.conn, p.enc, p.closed
+	p.connMu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if conn == nil {
+		var err error
+		if conn, err = dialer.dial(p.addr, dialer.dialTimeout); err != nil {
+			return err
+		}
+		enc = json.NewEncoder(conn)
+		p.connMu.Lock()
+		if p.closed { //连接期间peer被移除或transport被关闭
+			p.connMu.Unlock()
+			conn.Close()
+			return ErrClosed
+		}
+		p.conn, p.enc = conn, enc
+		p.connMu.Unlock()
+	}
+	conn.SetWriteDeadline(time.Now().Add(dialer.writeTimeout))
+	if err := enc.Encode(msg); err != nil {
+		conn.Close()
+		p.connMu.Lock()
+		if p.conn == conn {
+			p.conn, p.enc = nil, nil
+		}
+		p.connMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+//关闭后的peer不再重连，关闭连接会让正在进行的写入立即失败
+func (p *tcpPeer) close() {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	p.closed = true
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.enc = nil, nil
+	}
+}
+
+func (t *TCPTransport) Subscribe(f func(msg Message)) (cancel func()) {
+	return t.subs.add(f)
+}
+
+func (t *TCPTransport) acceptLoop() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.in[conn] = struct{}{}
+		t.wg.Add(1)
+		t.mu.Unlock()
+		go t.readLoop(conn)
+	}
+}
+
+func (t *TCPTransport) readLoop(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.in, conn)
+		t.mu.Unlock()
+		conn.Close()
+		t.wg.Done()
+	}()
+	dec := json.NewDecoder(conn)
+	for {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		t.subs.dispatch(msg)
+	}
+}
+
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.ln.Close()
+	out := t.out
+	t.out = make(map[string]*tcpPeer)
+	for conn := range t.in {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	for _, p := range out {
+		p.close()
+	}
+	t.wg.Wait()
+	return err
+}
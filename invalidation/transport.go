@@ -0,0 +1,136 @@
+//Package invalidation 在多个进程的同名CacheTable之间广播失效消息。
+//
+//每个副本用Broadcaster包装本地表，通过Broadcaster执行的Delete/Flush/InvalidateTag会先作用于本地，
+//再经由Transport发送给其他副本；收到的消息直接作用于本地表，不会再次发送，也会忽略自己发出的消息。
+package invalidation
+
+import (
+	"errors"
+	"sync"
+)
+
+type Op uint8
+
+const (
+	OpDelete Op = iota + 1
+	OpFlush
+	OpInvalidateTag
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpDelete:
+		return "delete"
+	case OpFlush:
+		return "flush"
+	case OpInvalidateTag:
+		return "invalidate_tag"
+	}
+	return "unknown"
+}
+
+//一条失效消息，Key只对OpDelete有效，Tag只对OpInvalidateTag有效
+type Message struct {
+	Origin string `json:"origin"` //发送者的标识，用于过滤自己发出的消息
+	Table  string `json:"table"`
+	Op     Op     `json:"op"`
+	Key    string `json:"key,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+}
+
+//传输层只负责把消息送到其他副本，不保证送达，也不保证顺序
+type Transport interface {
+	Publish(msg Message) error
+	//f可能在Transport的内部goroutine中被调用，返回的cancel用于取消订阅
+	Subscribe(f func(msg Message)) (cancel func())
+	Close() error
+}
+
+var ErrClosed = errors.New("invalidation: transport closed")
+
+//各Transport实现共用的订阅者列表
+type subscribers struct {
+	sync.RWMutex
+	nextID int
+	subs   map[int]func(msg Message)
+}
+
+func (s *subscribers) add(f func(msg Message)) (cancel func()) {
+	s.Lock()
+	if s.subs == nil {
+		s.subs = make(map[int]func(msg Message))
+	}
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = f
+	s.Unlock()
+	return func() {
+		s.Lock()
+		delete(s.subs, id)
+		s.Unlock()
+	}
+}
+
+func (s *subscribers) dispatch(msg Message) {
+	s.RLock()
+	fs := make([]func(msg Message), 0, len(s.subs))
+	for _, f := range s.subs {
+		fs = append(fs, f)
+	}
+	s.RUnlock()
+	for _, f := range fs {
+		f(msg)
+	}
+}
+
+//进程内的消息总线，每个Join得到的Transport相当于一个副本，主要用于测试
+type MemoryBus struct {
+	sync.RWMutex
+	members map[*memoryTransport]struct{}
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{members: make(map[*memoryTransport]struct{})}
+}
+
+func (b *MemoryBus) Join() Transport {
+	t := &memoryTransport{bus: b}
+	b.Lock()
+	b.members[t] = struct{}{}
+	b.Unlock()
+	return t
+}
+
+type memoryTransport struct {
+	bus  *MemoryBus
+	subs subscribers
+}
+
+//同步投递给总线上的所有成员(包括自己)
+func (t *memoryTransport) Publish(msg Message) error {
+	t.bus.RLock()
+	if _, ok := t.bus.members[t]; !ok {
+		t.bus.RUnlock()
+		return ErrClosed
+	}
+	members := make([]*memoryTransport, 0, len(t.bus.members))
+	for m := range t.bus.members {
+		members = append(members, m)
+	}
+	t.bus.RUnlock()
+	for _, m := range members {
+		m.subs.dispatch(msg)
+	}
+	return nil
+}
+
+func (t *memoryTransport) Subscribe(f func(msg Message)) (cancel func()) {
+	return t.subs.add(f)
+}
+
+func (t *memoryTransport) Close() error {
+	t.bus.Lock()
+	delete(t.bus.members, t)
+	t.bus.Unlock()
+	return nil
+}
@@ -0,0 +1,165 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const maxDatagramSize = 64 * 1024
+
+//读取出错后的退避时间，连续出错时翻倍直到上限，成功读取一次后恢复
+const (
+	minReadBackoff = 5 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+//每条消息编码为一个JSON数据报，发送给所有目标地址
+//目标地址可以是各副本的单播地址，也可以是一个组播地址
+type UDPTransport struct {
+	conn *net.UDPConn
+	subs subscribers
+
+	mu      sync.RWMutex
+	targets []*net.UDPAddr
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+//在addr上接收消息，并向peers发送
+func ListenUDP(addr string, peers ...string) (*UDPTransport, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	t := newUDPTransport(conn)
+	if err := t.SetPeers(peers...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+//加入组播组group(如239.0.0.1:9999)，消息发送到同一个组，ifi为nil时使用系统默认接口
+func ListenMulticastUDP(group string, ifi *net.Interface) (*UDPTransport, error) {
+	gaddr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp", ifi, gaddr)
+	if err != nil {
+		return nil, err
+	}
+	t := newUDPTransport(conn)
+	t.targets = []*net.UDPAddr{gaddr}
+	return t, nil
+}
+
+func newUDPTransport(conn *net.UDPConn) *UDPTransport {
+	t := &UDPTransport{
+		conn: conn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go t.readLoop()
+	return t
+}
+
+func (t *UDPTransport) Addr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+//替换发送的目标地址
+func (t *UDPTransport) SetPeers(peers ...string) error {
+	targets := make([]*net.UDPAddr, 0, len(peers))
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, addr)
+	}
+	t.mu.Lock()
+	t.targets = targets
+	t.mu.Unlock()
+	return nil
+}
+
+//向所有目标发送，返回遇到的第一个错误
+func (t *UDPTransport) Publish(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return ErrClosed
+	}
+	var firstErr error
+	for _, addr := range t.targets {
+		if _, err := t.conn.WriteToUDP(data, addr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *UDPTransport) Subscribe(f func(msg Message)) (cancel func()) {
+	return t.subs.add(f)
+}
+
+func (t *UDPTransport) readLoop() {
+	defer close(t.done)
+	buf := make([]byte, maxDatagramSize)
+	backoff := time.Duration(0)
+	for {
+		n, _, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			//其他错误(如ICMP不可达导致的ECONNREFUSED)可能持续出现，退避后重试，避免空转
+			if backoff == 0 {
+				backoff = minReadBackoff
+			} else if backoff *= 2; backoff > maxReadBackoff {
+				backoff = maxReadBackoff
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-t.stop:
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		backoff = 0
+		var msg Message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			continue //忽略无法解析的数据报
+		}
+		t.subs.dispatch(msg)
+	}
+}
+
+func (t *UDPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	close(t.stop)
+	err := t.conn.Close()
+	<-t.done
+	return err
+}
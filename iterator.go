@@ -0,0 +1,67 @@
+package memory_cache
+
+import "time"
+
+//CacheIterator在创建时对table做一次快照，迭代过程中不持有table的锁，
+//因此迭代期间可以对table做任意的读写，包括删除当前item
+//快照之后被删除、覆盖或已经过期的item会被跳过，快照之后新加入的item不会被迭代到
+
+type CacheIterator struct {
+	table *CacheTable
+	items []*CacheItem
+	pos   int
+	cur   *CacheItem
+}
+
+//创建迭代器，只在复制快照时短暂持有读锁
+func (table *CacheTable) Iterator() *CacheIterator {
+	it, _ := table.IteratorWithSeq()
+	return it
+}
+
+//创建迭代器，同时返回快照时刻的事件序号
+//序号不超过seq的事件都已经反映在快照中，可以和Watch配合实现先全量再增量的同步
+func (table *CacheTable) IteratorWithSeq() (*CacheIterator, uint64) {
+	table.RLock() //事件只在持有写锁时产生，持有读锁期间序号不会变化
+	items := make([]*CacheItem, 0, len(table.items))
+	for _, item := range table.items {
+		items = append(items, item)
+	}
+	seq := table.EventSeq()
+	table.RUnlock()
+	return &CacheIterator{
+		table: table,
+		items: items,
+	}, seq
+}
+
+//移动到下一个有效的item，没有更多item时返回false
+func (it *CacheIterator) Next() bool {
+	now := time.Now()
+	for it.pos < len(it.items) {
+		item := it.items[it.pos]
+		it.pos++
+
+		it.table.RLock()
+		cur, ok := it.table.items[item.key]
+		it.table.RUnlock()
+		if !ok || cur != item || item.expired(now) {
+			continue
+		}
+		it.cur = item
+		return true
+	}
+	it.cur = nil
+	return false
+}
+
+func (it *CacheIterator) Key() interface{} {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.key
+}
+
+func (it *CacheIterator) Item() *CacheItem {
+	return it.cur
+}
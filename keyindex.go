@@ -0,0 +1,123 @@
+package memory_cache
+
+import (
+	"math/rand"
+	"strings"
+)
+
+//基于跳表的有序key索引，只对string类型的key建立索引
+//所有操作都在table的锁保护下进行，自身不加锁
+
+const keyIndexMaxLevel = 32
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+}
+
+type keyIndex struct {
+	head   *skipNode
+	level  int
+	length int
+	rand   *rand.Rand
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:  &skipNode{next: make([]*skipNode, keyIndexMaxLevel)},
+		level: 1,
+		rand:  rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+//每升高一层的概率为1/4
+func (idx *keyIndex) randomLevel() int {
+	level := 1
+	for level < keyIndexMaxLevel && idx.rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+//查找每一层中最后一个小于key的节点
+func (idx *keyIndex) predecessors(key string) []*skipNode {
+	update := make([]*skipNode, keyIndexMaxLevel)
+	node := idx.head
+	for i := idx.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return update
+}
+
+func (idx *keyIndex) insert(key string) {
+	update := idx.predecessors(key)
+	if next := update[0].next[0]; next != nil && next.key == key { //已存在
+		return
+	}
+	level := idx.randomLevel()
+	if level > idx.level {
+		for i := idx.level; i < level; i++ {
+			update[i] = idx.head
+		}
+		idx.level = level
+	}
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	idx.length++
+}
+
+func (idx *keyIndex) remove(key string) {
+	update := idx.predecessors(key)
+	node := update[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+	idx.length--
+}
+
+//从第一个不小于from的key开始顺序遍历，fn返回false时停止
+func (idx *keyIndex) ascend(from string, fn func(key string) bool) {
+	node := idx.predecessors(from)[0].next[0]
+	for ; node != nil; node = node.next[0] {
+		if !fn(node.key) {
+			return
+		}
+	}
+}
+
+func (idx *keyIndex) prefix(prefix string) []string {
+	keys := []string{}
+	idx.ascend(prefix, func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+//返回[from, to)区间内的key，to为空表示没有上界
+func (idx *keyIndex) between(from, to string) []string {
+	keys := []string{}
+	idx.ascend(from, func(key string) bool {
+		if to != "" && key >= to {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
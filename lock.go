@@ -0,0 +1,99 @@
+package memory_cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//进程内的租约锁，锁作为item保存在table中，持有者失联时随item过期自动释放
+//每次加锁都会分配一个table内单调递增的fencing token，被锁保护的资源可以据此拒绝旧持有者的迟到写入
+//table内嵌的sync.RWMutex已占用Lock、Unlock和TryLock，这里的方法都带Key或Lock后缀
+//锁对应的key不要用Value读取，Value会刷新访问时间从而延长租约，查看持有者使用LockHolder
+
+//保存在锁item中的数据
+type Lease struct {
+	Owner string
+	Token uint64 //fencing token
+}
+
+//key未被锁或者锁已过期时加锁，返回fencing token，ttl必须大于0
+//同一个owner重复加锁也会返回ErrLocked，延长租约使用RenewLock
+func (table *CacheTable) TryLockKey(key interface{}, owner string, ttl time.Duration) (uint64, error) {
+	if ttl <= 0 {
+		return 0, ErrInvalidLockTTL
+	}
+	item := NewCacheItem(key, nil, ttl)
+	locked, err := table.addIf(item, func(old *CacheItem) bool {
+		if old != nil && !old.expired(time.Now()) {
+			return false
+		}
+		//在table的写锁内分配token，保证后加锁的token一定更大
+		item.data = Lease{Owner: owner, Token: atomic.AddUint64(&table.fencing, 1)}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, ErrLocked
+	}
+	return item.data.(Lease).Token, nil
+}
+
+//owner仍持有锁时把租约延长为从现在起的ttl，fencing token不变
+func (table *CacheTable) RenewLock(key interface{}, owner string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidLockTTL
+	}
+	item := NewCacheItem(key, nil, ttl)
+	renewed, err := table.addIf(item, func(old *CacheItem) bool {
+		if !heldBy(old, owner) {
+			return false
+		}
+		item.data = old.data
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return ErrNotLockOwner
+	}
+	return nil
+}
+
+//owner仍持有锁时释放，检查与删除是原子的，不会误删别人在过期后重新加的锁
+func (table *CacheTable) UnlockKey(key interface{}, owner string) error {
+	item, err := table.Peek(key)
+	if err != nil || !heldBy(item, owner) {
+		return ErrNotLockOwner
+	}
+	if _, err := table.deleteItem(key, item, EventDeleted); err != nil {
+		if err == ErrNotFound {
+			return ErrNotLockOwner
+		}
+		return err
+	}
+	return nil
+}
+
+//当前持有锁的租约，没有被锁或者锁已过期时返回ErrNotFound
+func (table *CacheTable) LockHolder(key interface{}) (Lease, error) {
+	item, err := table.Peek(key)
+	if err != nil {
+		return Lease{}, err
+	}
+	lease, ok := item.Data().(Lease)
+	if !ok || item.expired(time.Now()) {
+		return Lease{}, ErrNotFound
+	}
+	return lease, nil
+}
+
+func heldBy(item *CacheItem, owner string) bool {
+	if item == nil || item.expired(time.Now()) {
+		return false
+	}
+	lease, ok := item.Data().(Lease)
+	return ok && lease.Owner == owner
+}
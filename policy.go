@@ -0,0 +1,143 @@
+package memory_cache
+
+import (
+	"container/list"
+	"strings"
+	"sync/atomic"
+)
+
+//容量受限table的淘汰策略
+//策略只记录key，容量由策略自己维护；方法都在table的写锁内调用，实现不需要自己加锁
+type EvictionPolicy interface {
+	//记录新加入table的key，返回需要淘汰的key，其中可能包括key本身，表示新key没有被准入
+	Add(key interface{}) (evicted []interface{})
+	//key被访问，访问是异步批量记录的，调用时key可能已经不在策略中
+	Access(key interface{})
+	//key被删除或过期
+	Remove(key interface{})
+	//table被清空
+	Reset()
+}
+
+//按名字创建淘汰策略，名字不区分大小写：lru、tinylfu(W-TinyLFU)、arc、2q，便于通过配置选择
+func NewEvictionPolicy(name string, capacity int) (EvictionPolicy, error) {
+	switch strings.ToLower(name) {
+	case "lru":
+		return NewLRUPolicy(capacity), nil
+	case "tinylfu", "w-tinylfu":
+		return NewTinyLFUPolicy(capacity), nil
+	case "arc":
+		return NewARCPolicy(capacity), nil
+	case "2q":
+		return New2QPolicy(capacity), nil
+	}
+	return nil, ErrUnknownPolicy
+}
+
+//最近最少使用，淘汰最久没有被访问的key
+type lruPolicy struct {
+	capacity int
+	ll       *list.List
+	elems    map[interface{}]*list.Element
+}
+
+func NewLRUPolicy(capacity int) EvictionPolicy {
+	p := &lruPolicy{capacity: capacity}
+	p.Reset()
+	return p
+}
+
+func (p *lruPolicy) Add(key interface{}) []interface{} {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+		return nil
+	}
+	p.elems[key] = p.ll.PushFront(key)
+	var evicted []interface{}
+	for p.ll.Len() > p.capacity {
+		back := p.ll.Back()
+		p.ll.Remove(back)
+		delete(p.elems, back.Value)
+		evicted = append(evicted, back.Value)
+	}
+	return evicted
+}
+
+func (p *lruPolicy) Access(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy) Reset() {
+	p.ll = list.New()
+	p.elems = make(map[interface{}]*list.Element)
+}
+
+//Value命中时缓冲的访问记录数量，缓冲满了之后的访问直接丢弃，只影响淘汰策略的精度
+const accessBufferSize = 1024
+
+//限制table的容量，p为nil时取消限制
+//已有的key按遍历顺序加入策略，超出容量的部分会被立即淘汰
+func (table *CacheTable) SetEvictionPolicy(p EvictionPolicy) {
+	table.Lock()
+	table.policy = p
+	table.accesses = nil
+	var evicted []*CacheItem
+	if p != nil {
+		table.accesses = make(chan interface{}, accessBufferSize)
+		for key := range table.items {
+			evicted = append(evicted, table.evictLocked(p.Add(key))...)
+		}
+	}
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+	for _, item := range evicted {
+		item.notifyDelete(aboutToDeleteItem)
+	}
+}
+
+//Value只持有读锁，不能直接修改policy，访问记录先放入缓冲，与Caffeine的read buffer类似
+func (table *CacheTable) recordAccess(accesses chan interface{}, key interface{}) {
+	select {
+	case accesses <- key:
+	default:
+	}
+}
+
+//把缓冲的访问记录交给policy，调用方需持有table的写锁
+func (table *CacheTable) drainAccesses() {
+	for {
+		select {
+		case key := <-table.accesses:
+			table.policy.Access(key)
+		default:
+			return
+		}
+	}
+}
+
+//移除policy要求淘汰的key，调用方需持有table的写锁，返回被移除的item以便解锁后执行回调
+func (table *CacheTable) evictLocked(keys []interface{}) []*CacheItem {
+	var evicted []*CacheItem
+	for _, key := range keys {
+		item, ok := table.items[key]
+		if !ok {
+			continue
+		}
+		table.log("Evicting item with key ", key, " from table ", table.name)
+		delete(table.items, key)
+		table.unindexItem(item)
+		table.publish(EventEvicted, key, item)
+		atomic.AddUint64(&table.evictions, 1)
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
@@ -0,0 +1,132 @@
+package memory_cache
+
+import (
+	"container/heap"
+	"sort"
+)
+
+//查询与统计相关的操作
+//Select在table的快照上进行，过滤和排序期间不持有table的锁
+//带limit的查询使用大小为limit的堆求top-k，复杂度为O(n*log(limit))，不需要对整个table排序
+
+//排序规则，a应该排在b前面时返回true
+type ItemLess func(a, b *CacheItem) bool
+
+var (
+	//访问次数从少到多
+	ByAccessedCount ItemLess = func(a, b *CacheItem) bool {
+		return a.AccessedCount() < b.AccessedCount()
+	}
+	//访问次数从多到少
+	ByMostAccessed ItemLess = func(a, b *CacheItem) bool {
+		return a.AccessedCount() > b.AccessedCount()
+	}
+	//创建时间从早到晚
+	ByCreatedOn ItemLess = func(a, b *CacheItem) bool {
+		return a.CreatedOn().Before(b.CreatedOn())
+	}
+	//最近访问时间从早到晚，即最久未被访问的在前
+	ByAccessedOn ItemLess = func(a, b *CacheItem) bool {
+		return a.AccessedOn().Before(b.AccessedOn())
+	}
+	//过期时间从近到远，永久有效的排在最后
+	ByExpiration ItemLess = func(a, b *CacheItem) bool {
+		ea, eb := a.ExpiresAt(), b.ExpiresAt()
+		if ea.IsZero() || eb.IsZero() {
+			return !ea.IsZero() && eb.IsZero()
+		}
+		return ea.Before(eb)
+	}
+)
+
+//返回满足predicate的item，按orderBy排序后取前limit个
+//predicate为nil表示不过滤，orderBy为nil表示不排序，limit<=0表示不限制个数
+func (table *CacheTable) Select(predicate func(item *CacheItem) bool, orderBy ItemLess, limit int) []*CacheItem {
+	h := &itemHeap{less: orderBy}
+	for it := table.Iterator(); it.Next(); {
+		item := it.Item()
+		if predicate != nil && !predicate(item) {
+			continue
+		}
+		switch {
+		case limit <= 0:
+			h.items = append(h.items, item)
+		case orderBy == nil:
+			if len(h.items) < limit {
+				h.items = append(h.items, item)
+			}
+		case len(h.items) < limit:
+			heap.Push(h, item)
+		case orderBy(item, h.items[0]): //比堆顶(当前top-k中排最后的)更靠前，替换掉堆顶
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+	}
+
+	if orderBy == nil {
+		return h.items
+	}
+	if limit <= 0 {
+		sort.SliceStable(h.items, func(i, j int) bool {
+			return orderBy(h.items[i], h.items[j])
+		})
+		return h.items
+	}
+	//堆顶是排在最后的，依次弹出后倒序填充
+	result := make([]*CacheItem, h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(*CacheItem)
+	}
+	return result
+}
+
+//获取前count个访问次数最少的item
+func (table *CacheTable) LeastAccessed(count int) []*CacheItem {
+	return table.Select(nil, ByAccessedCount, count)
+}
+
+//获取前count个最早创建的item
+func (table *CacheTable) OldestCreated(count int) []*CacheItem {
+	return table.Select(nil, ByCreatedOn, count)
+}
+
+//获取前count个最久未被访问的item
+func (table *CacheTable) LeastRecentlyAccessed(count int) []*CacheItem {
+	return table.Select(nil, ByAccessedOn, count)
+}
+
+//获取前count个最快过期的item，永久有效的item不会被返回
+func (table *CacheTable) ExpiringSoon(count int) []*CacheItem {
+	return table.Select(func(item *CacheItem) bool {
+		return item.LifeSpan() > 0
+	}, ByExpiration, count)
+}
+
+//按less的逆序组织的堆，堆顶是当前结果中排在最后的item
+type itemHeap struct {
+	items []*CacheItem
+	less  ItemLess
+}
+
+func (h *itemHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *itemHeap) Less(i, j int) bool {
+	return h.less(h.items[j], h.items[i])
+}
+
+func (h *itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*CacheItem))
+}
+
+func (h *itemHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
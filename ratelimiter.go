@@ -0,0 +1,234 @@
+package memory_cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//基于CacheTable的按key限流，每个key的状态作为item保存在table中，
+//空闲的key随item过期被清理，table应只用于限流，不要设置loadData
+
+type RateAlgorithm int
+
+const (
+	TokenBucket      RateAlgorithm = iota //令牌桶，容量为Limit，每Window补满，允许突发
+	SlidingWindowLog                      //滑动窗口日志，任意Window长的时间内最多Limit次，精确但每个key要保存Limit个时间戳
+)
+
+type RateLimiterOptions struct {
+	Algorithm RateAlgorithm
+	Limit     int           //每个Window内允许的请求数
+	Window    time.Duration //空闲超过Window的key会过期，之后的状态与新key相同
+}
+
+type RateLimiter struct {
+	table *CacheTable
+	opts  RateLimiterOptions
+	rate  float64 //令牌桶每秒补充的令牌数
+	now   func() time.Time
+}
+
+func NewRateLimiter(table *CacheTable, opts RateLimiterOptions) (*RateLimiter, error) {
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+	if opts.Algorithm != TokenBucket && opts.Algorithm != SlidingWindowLog {
+		return nil, ErrInvalidRateLimit
+	}
+	return &RateLimiter{
+		table: table,
+		opts:  opts,
+		rate:  float64(opts.Limit) / opts.Window.Seconds(),
+		now:   time.Now,
+	}, nil
+}
+
+//一次预留的结果
+type Reservation struct {
+	ok     bool
+	delay  time.Duration
+	cancel func()
+}
+
+//n为负数或超过Limit时永远无法满足，返回false，此时没有预留任何配额
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+//需要等待多久才能执行被预留的请求，0表示可以立即执行
+func (r *Reservation) Delay() time.Duration {
+	return r.delay
+}
+
+//放弃预留，把配额还给后面的请求
+func (r *Reservation) Cancel() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+//key现在是否允许一次请求，不允许时不消耗配额
+func (l *RateLimiter) Allow(key interface{}) bool {
+	return l.AllowN(key, 1)
+}
+
+func (l *RateLimiter) AllowN(key interface{}, n int) bool {
+	return l.take(key, n, false).ok
+}
+
+//预留n次请求的配额，配额不足时也会预留，调用方需要等待Delay后再执行，或者Cancel
+func (l *RateLimiter) Reserve(key interface{}, n int) *Reservation {
+	return l.take(key, n, true)
+}
+
+//阻塞到key允许一次请求，ctx先结束或者其截止时间早于可执行的时间时返回错误，且不消耗配额
+func (l *RateLimiter) Wait(ctx context.Context, key interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := l.Reserve(key, 1)
+	if r.delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && l.now().Add(r.delay).After(deadline) {
+		r.Cancel()
+		return ErrRateLimited
+	}
+	timer := time.NewTimer(r.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+//n<0和n>Limit一样返回不OK的预留；n==0不需要配额，直接成功，也不会为key创建状态
+func (l *RateLimiter) take(key interface{}, n int, reserve bool) *Reservation {
+	if n < 0 || n > l.opts.Limit {
+		return &Reservation{}
+	}
+	if n == 0 {
+		return &Reservation{ok: true}
+	}
+	now := l.now()
+	item := l.state(key)
+	var r *Reservation
+	switch state := item.Data().(type) {
+	case *tokenBucket:
+		r = state.take(now, n, l.rate, float64(l.opts.Limit), reserve)
+	case *windowLog:
+		r = state.take(now, n, l.opts.Limit, l.opts.Window, reserve)
+	}
+	//预留了未来的配额时延长生命周期，避免状态在配额用完之前过期
+	if r.delay > 0 {
+		l.table.Touch(key, l.opts.Window+r.delay)
+	}
+	return r
+}
+
+//取出key的状态，不存在时新建，Value会刷新item的访问时间，空闲的key在Window后过期
+func (l *RateLimiter) state(key interface{}) *CacheItem {
+	for {
+		if item, err := l.table.Value(key); err == nil {
+			return item
+		}
+		var data interface{}
+		if l.opts.Algorithm == TokenBucket {
+			data = &tokenBucket{tokens: float64(l.opts.Limit), last: l.now()}
+		} else {
+			data = &windowLog{}
+		}
+		if l.table.NotFoundAdd(key, data, l.opts.Window) {
+			if item, err := l.table.Peek(key); err == nil {
+				return item
+			}
+		}
+	}
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	tokens float64 //可以为负，表示已被预留的未来令牌
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, n int, rate, capacity float64, reserve bool) *Reservation {
+	b.Lock()
+	defer b.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.last = now
+	}
+	tokens := b.tokens - float64(n)
+	var delay time.Duration
+	if tokens < 0 {
+		if !reserve {
+			return &Reservation{}
+		}
+		delay = time.Duration(-tokens / rate * float64(time.Second))
+	}
+	b.tokens = tokens
+	return &Reservation{ok: true, delay: delay, cancel: func() {
+		b.Lock()
+		b.tokens += float64(n)
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.Unlock()
+	}}
+}
+
+type windowLog struct {
+	sync.Mutex
+	times []time.Time //升序，可能包含预留的未来时间
+}
+
+func (w *windowLog) take(now time.Time, n, limit int, window time.Duration, reserve bool) *Reservation {
+	w.Lock()
+	defer w.Unlock()
+	expired := 0
+	for expired < len(w.times) && !w.times[expired].After(now.Add(-window)) {
+		expired++
+	}
+	w.times = w.times[expired:]
+
+	//n个请求在at时刻执行时，at之前Window内最多只能有limit-n个请求
+	at := now
+	if len(w.times) > 0 && w.times[len(w.times)-1].After(at) {
+		at = w.times[len(w.times)-1]
+	}
+	if i := len(w.times) - (limit - n) - 1; i >= 0 {
+		if t := w.times[i].Add(window); t.After(at) {
+			at = t
+		}
+	}
+	delay := at.Sub(now)
+	if delay > 0 && !reserve {
+		return &Reservation{}
+	}
+	for i := 0; i < n; i++ {
+		w.times = append(w.times, at)
+	}
+	return &Reservation{ok: true, delay: delay, cancel: func() {
+		w.Lock()
+		removed := 0
+		times := w.times[:0]
+		for _, t := range w.times {
+			if removed < n && t.Equal(at) {
+				removed++
+				continue
+			}
+			times = append(times, t)
+		}
+		w.times = times
+		w.Unlock()
+	}}
+}
@@ -0,0 +1,103 @@
+//Package replication 把一个CacheTable的修改从主节点流式复制到只读副本。
+//
+//副本通过HTTP连接主节点，首次连接时先收到整个table的快照，之后按事件序号顺序收到Add/Delete/Expire/Flush操作。
+//主节点在内存中保留最近的一段操作，副本断线重连时带上自己最后应用的序号，只要这些操作仍在保留范围内就从断点继续，
+//否则重新做一次全量同步。
+//
+//只复制string类型的key和[]byte、string类型的value，其他item在主节点上被跳过。
+//副本上的item没有生命周期，过期由主节点的Expire操作驱动，副本的内容始终以主节点为准。
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+type OpType uint8
+
+const (
+	OpAdd OpType = iota + 1
+	OpDelete
+	OpExpire
+	OpFlush
+	OpNoop          //无法复制的item产生的事件，只用于推进序号
+	OpSnapshotBegin //全量快照开始，副本应清空本地table
+	OpSnapshotEnd   //全量快照结束，Seq为快照对应的序号
+	OpHeartbeat     //空闲时发送，Seq为主节点当前的序号
+)
+
+func (t OpType) String() string {
+	switch t {
+	case OpAdd:
+		return "add"
+	case OpDelete:
+		return "delete"
+	case OpExpire:
+		return "expire"
+	case OpFlush:
+		return "flush"
+	case OpNoop:
+		return "noop"
+	case OpSnapshotBegin:
+		return "snapshot_begin"
+	case OpSnapshotEnd:
+		return "snapshot_end"
+	case OpHeartbeat:
+		return "heartbeat"
+	}
+	return "unknown"
+}
+
+//复制流中的一条记录，每行一个JSON对象
+type Op struct {
+	Seq   uint64   `json:"seq"`
+	Type  OpType   `json:"type"`
+	Key   string   `json:"key,omitempty"`
+	Value []byte   `json:"value,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	ID    string   `json:"id,omitempty"` //主节点的运行标识，只在OpSnapshotBegin中出现
+}
+
+//把table事件转换为复制操作
+func opFromEvent(event memory_cache.TableEvent) Op {
+	op := Op{Seq: event.Seq, Type: OpNoop}
+	if event.Type == memory_cache.EventFlushed {
+		op.Type = OpFlush
+		return op
+	}
+	key, ok := event.Key.(string)
+	if !ok {
+		return op
+	}
+	switch event.Type {
+	case memory_cache.EventAdded:
+		value, ok := encodeValue(event.Item.Data())
+		if !ok {
+			return op
+		}
+		op.Type, op.Key, op.Value, op.Tags = OpAdd, key, value, event.Item.Tags()
+	case memory_cache.EventDeleted:
+		op.Type, op.Key = OpDelete, key
+	case memory_cache.EventExpired, memory_cache.EventEvicted: //淘汰与过期一样是主节点自动移除
+		op.Type, op.Key = OpExpire, key
+	}
+	return op
+}
+
+func encodeValue(data interface{}) ([]byte, bool) {
+	switch v := data.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
+func newRunID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,180 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const (
+	DefaultBacklogSize       = 10000
+	DefaultHeartbeatInterval = time.Second
+)
+
+//主节点，作为http.Handler向副本提供复制流
+//
+//	GET /?id={主节点运行标识}&from={副本最后应用的序号}
+//
+//id与当前主节点一致并且from之后的操作仍在保留范围内时从from继续，否则先发送全量快照
+type Primary struct {
+	table             *memory_cache.CacheTable
+	id                string
+	size              int
+	HeartbeatInterval time.Duration
+
+	mu      sync.Mutex
+	backlog []Op          //最近的操作，序号连续递增
+	notify  chan struct{} //有新操作时关闭并替换，用于唤醒等待中的复制流
+	cancel  func()
+}
+
+//backlogSize为至少保留的最近操作数量，<=0时使用DefaultBacklogSize
+func NewPrimary(table *memory_cache.CacheTable, backlogSize int) *Primary {
+	if backlogSize <= 0 {
+		backlogSize = DefaultBacklogSize
+	}
+	p := &Primary{
+		table:             table,
+		id:                newRunID(),
+		size:              backlogSize,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		notify:            make(chan struct{}),
+	}
+	p.cancel = table.Watch(p.record)
+	return p
+}
+
+func (p *Primary) ID() string {
+	return p.id
+}
+
+//停止记录table的事件，已建立的复制流在空闲时不会再收到新操作
+func (p *Primary) Close() {
+	p.cancel()
+}
+
+func (p *Primary) record(event memory_cache.TableEvent) {
+	op := opFromEvent(event)
+	p.mu.Lock()
+	//table的事件队列写满时会丢弃事件，backlog不再连续，清空后所有副本都会重新全量同步
+	if n := len(p.backlog); n > 0 && op.Seq != p.backlog[n-1].Seq+1 {
+		p.backlog = nil
+	}
+	p.backlog = append(p.backlog, op)
+	if len(p.backlog) >= 2*p.size { //超过两倍时才整理，避免每次都复制
+		p.backlog = append([]Op(nil), p.backlog[len(p.backlog)-p.size:]...)
+	}
+	close(p.notify)
+	p.notify = make(chan struct{})
+	p.mu.Unlock()
+}
+
+//取出序号大于from的操作，behind表示from之后的操作已经被丢弃
+func (p *Primary) since(from uint64) (ops []Op, notify <-chan struct{}, behind bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	notify = p.notify
+	if len(p.backlog) == 0 {
+		return nil, notify, false
+	}
+	first := p.backlog[0].Seq
+	last := p.backlog[len(p.backlog)-1].Seq
+	if first > from+1 {
+		return nil, notify, true
+	}
+	if from >= last {
+		return nil, notify, false
+	}
+	ops = append([]Op(nil), p.backlog[from+1-first:]...)
+	return ops, notify, false
+}
+
+func (p *Primary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	resume := err == nil && r.URL.Query().Get("id") == p.id
+	if resume {
+		if _, _, behind := p.since(from); behind {
+			resume = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	if !resume {
+		if from, err = p.writeSnapshot(enc); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	p.stream(r.Context(), enc, flusher, from)
+}
+
+func (p *Primary) writeSnapshot(enc *json.Encoder) (uint64, error) {
+	it, seq := p.table.IteratorWithSeq()
+	if err := enc.Encode(Op{Seq: seq, Type: OpSnapshotBegin, ID: p.id}); err != nil {
+		return 0, err
+	}
+	for it.Next() {
+		key, ok := it.Key().(string)
+		if !ok {
+			continue
+		}
+		value, ok := encodeValue(it.Item().Data())
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(Op{Seq: seq, Type: OpAdd, Key: key, Value: value, Tags: it.Item().Tags()}); err != nil {
+			return 0, err
+		}
+	}
+	return seq, enc.Encode(Op{Seq: seq, Type: OpSnapshotEnd})
+}
+
+//持续发送from之后的操作，副本落后太多时断开连接，由副本重连后重新全量同步
+func (p *Primary) stream(ctx context.Context, enc *json.Encoder, flusher http.Flusher, from uint64) {
+	ticker := time.NewTicker(p.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		ops, notify, behind := p.since(from)
+		if behind {
+			return
+		}
+		for _, op := range ops {
+			if err := enc.Encode(op); err != nil {
+				return
+			}
+			from = op.Seq
+		}
+		if len(ops) > 0 {
+			flusher.Flush()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-notify:
+		case <-ticker.C:
+			if err := enc.Encode(Op{Seq: p.table.EventSeq(), Type: OpHeartbeat}); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const DefaultRetryInterval = time.Second
+
+//副本的复制状态
+type Status struct {
+	PrimaryID   string    //当前跟随的主节点运行标识，完成第一次全量同步前为空
+	Connected   bool      //是否正在接收复制流
+	LastSeq     uint64    //最后应用的操作序号
+	PrimarySeq  uint64    //已知的主节点最新序号
+	Lag         uint64    //落后主节点的操作数
+	LastContact time.Time //最后一次收到主节点数据的时间
+	FullSyncs   int       //完成全量同步的次数
+}
+
+//副本，把主节点的操作应用到本地table，本地table应视为只读
+type Replica struct {
+	table         *memory_cache.CacheTable
+	primary       string
+	client        *http.Client
+	RetryInterval time.Duration
+
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+//primary为主节点Primary handler的URL
+func NewReplica(table *memory_cache.CacheTable, primary string, client *http.Client) *Replica {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Replica{
+		table:         table,
+		primary:       primary,
+		client:        client,
+		RetryInterval: DefaultRetryInterval,
+	}
+}
+
+//在后台开始复制，断线后每隔RetryInterval重连
+func (r *Replica) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx)
+}
+
+//停止复制，本地table保留已经同步的内容
+func (r *Replica) Close() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	if s.PrimarySeq > s.LastSeq {
+		s.Lag = s.PrimarySeq - s.LastSeq
+	}
+	return s
+}
+
+func (r *Replica) run(ctx context.Context) {
+	defer close(r.done)
+	for {
+		r.sync(ctx)
+		r.mu.Lock()
+		r.status.Connected = false
+		r.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.RetryInterval):
+		}
+	}
+}
+
+//建立一次复制流并持续应用，直到连接断开
+func (r *Replica) sync(ctx context.Context) error {
+	r.mu.Lock()
+	query := url.Values{}
+	if r.status.PrimaryID != "" {
+		query.Set("id", r.status.PrimaryID)
+		query.Set("from", strconv.FormatUint(r.status.LastSeq, 10))
+	}
+	r.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, r.primary+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("replication: primary returned %s", resp.Status)
+	}
+
+	r.mu.Lock()
+	r.status.Connected = true
+	r.mu.Unlock()
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	var snapshotID string //全量同步进行中时为新主节点的标识
+	for {
+		var op Op
+		if err := dec.Decode(&op); err != nil {
+			return err
+		}
+		r.apply(op, &snapshotID)
+	}
+}
+
+func (r *Replica) apply(op Op, snapshotID *string) {
+	switch op.Type {
+	case OpSnapshotBegin:
+		r.table.Flush()
+		*snapshotID = op.ID
+	case OpAdd:
+		r.table.AddWithTags(op.Key, op.Value, 0, op.Tags...)
+	case OpDelete, OpExpire:
+		r.table.Delete(op.Key)
+	case OpFlush:
+		r.table.Flush()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.LastContact = time.Now()
+	if op.Seq > r.status.PrimarySeq {
+		r.status.PrimarySeq = op.Seq
+	}
+	switch {
+	case op.Type == OpSnapshotEnd:
+		//快照完整应用后才更新标识和序号，快照中途断开时下次重新全量同步
+		r.status.PrimaryID = *snapshotID
+		r.status.LastSeq = op.Seq
+		r.status.FullSyncs++
+		*snapshotID = ""
+	case op.Type == OpHeartbeat, *snapshotID != "":
+	default:
+		r.status.LastSeq = op.Seq
+	}
+}
@@ -0,0 +1,206 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//副本的内容与主节点一致
+func sameContent(primary, replica *memory_cache.CacheTable) bool {
+	if primary.Count() != replica.Count() {
+		return false
+	}
+	same := true
+	primary.Foreach(func(key interface{}, item *memory_cache.CacheItem) {
+		got, err := replica.Peek(key)
+		if err != nil {
+			same = false
+			return
+		}
+		want, _ := encodeValue(item.Data())
+		if !bytes.Equal(got.Data().([]byte), want) {
+			same = false
+		}
+	})
+	return same
+}
+
+type node struct {
+	primary *Primary
+	server  *httptest.Server
+	down    int32 //置为1时拒绝复制请求并断开现有连接，模拟网络故障
+}
+
+func startPrimary(t *testing.T, table *memory_cache.CacheTable, backlog int) *node {
+	n := &node{primary: NewPrimary(table, backlog)}
+	n.primary.HeartbeatInterval = 20 * time.Millisecond
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&n.down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		n.primary.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		n.server.Close()
+		n.primary.Close()
+	})
+	return n
+}
+
+func (n *node) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&n.down, 1)
+		n.server.CloseClientConnections()
+	} else {
+		atomic.StoreInt32(&n.down, 0)
+	}
+}
+
+func startReplica(t *testing.T, table *memory_cache.CacheTable, url string) *Replica {
+	r := NewReplica(table, url, nil)
+	r.RetryInterval = 10 * time.Millisecond
+	r.Start()
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestReplication(t *testing.T) {
+	primary := memory_cache.Cache("replication_primary")
+	replica := memory_cache.Cache("replication_replica")
+	primary.Flush() //注册表中的table在多次运行之间共享
+	replica.Flush()
+	primary.Add("before", []byte("snapshot"), 0)
+	primary.AddWithTags("tagged", "s", 0, "t1")
+	primary.Add(42, []byte("int key is skipped"), 0)
+
+	n := startPrimary(t, primary, 0)
+	r := startReplica(t, replica, n.server.URL)
+	waitFor(t, "snapshot", func() bool { return r.Status().FullSyncs == 1 })
+	if replica.Count() != 2 || len(replica.KeysByTag("t1")) != 1 {
+		t.Fatal("unexpected snapshot", replica.Count())
+	}
+	primary.Delete(42)
+
+	primary.Add("a", []byte("1"), 0)
+	primary.Add("b", []byte("2"), 0)
+	primary.Add("a", []byte("3"), 0)
+	primary.Delete("before")
+	primary.Add("short", []byte("x"), 50*time.Millisecond)
+	waitFor(t, "replicated ops", func() bool { return replica.Exists("short") })
+	waitFor(t, "expiry", func() bool { return !replica.Exists("short") })
+	waitFor(t, "same content", func() bool { return sameContent(primary, replica) })
+	waitFor(t, "no lag", func() bool {
+		s := r.Status()
+		return s.Connected && s.Lag == 0 && s.LastSeq == primary.EventSeq()
+	})
+
+	primary.Flush()
+	primary.Add("c", []byte("after flush"), 0)
+	waitFor(t, "flush", func() bool { return sameContent(primary, replica) })
+
+	if s := r.Status(); s.PrimaryID != n.primary.ID() || s.FullSyncs != 1 {
+		t.Error("unexpected status", s)
+	}
+}
+
+func TestReplicationResume(t *testing.T) {
+	primary := memory_cache.Cache("replication_resume_primary")
+	replica := memory_cache.Cache("replication_resume_replica")
+	primary.Flush()
+	replica.Flush()
+	n := startPrimary(t, primary, 100)
+	r := startReplica(t, replica, n.server.URL)
+	primary.Add("k", []byte("v"), 0)
+	waitFor(t, "initial sync", func() bool { return replica.Exists("k") })
+
+	//短暂断开后从断点继续，不需要重新全量同步
+	n.setDown(true)
+	waitFor(t, "disconnect", func() bool { return !r.Status().Connected })
+	for i := 0; i < 10; i++ {
+		primary.Add(fmt.Sprint("k", i), []byte("v"), 0)
+	}
+	waitFor(t, "lag", func() bool { return primary.EventSeq()-r.Status().LastSeq == 10 })
+	n.setDown(false)
+	waitFor(t, "resume", func() bool { return sameContent(primary, replica) && r.Status().Lag == 0 })
+	if s := r.Status(); s.FullSyncs != 1 {
+		t.Error("resume should not resync", s)
+	}
+
+	//断开期间的操作超出主节点保留范围时重新全量同步
+	n.setDown(true)
+	waitFor(t, "disconnect", func() bool { return !r.Status().Connected })
+	for i := 0; i < 300; i++ {
+		primary.Add(fmt.Sprint("x", i), []byte("v"), 0)
+	}
+	primary.Delete("k")
+	n.setDown(false)
+	waitFor(t, "resync", func() bool { return sameContent(primary, replica) && r.Status().FullSyncs == 2 })
+	if replica.Exists("k") {
+		t.Error("deleted key survived resync")
+	}
+}
+
+func TestReplicaFollowsNewPrimary(t *testing.T) {
+	primary := memory_cache.Cache("replication_restart_primary")
+	replica := memory_cache.Cache("replication_restart_replica")
+	primary.Flush()
+	replica.Flush()
+	primary.Add("k", []byte("v"), 0)
+
+	first := NewPrimary(primary, 0)
+	var current atomic.Value
+	current.Store(first)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().(*Primary).ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	r := startReplica(t, replica, server.URL)
+	waitFor(t, "initial sync", func() bool { return r.Status().FullSyncs == 1 })
+
+	//主节点重启后运行标识变化，副本必须重新全量同步
+	first.Close()
+	second := NewPrimary(primary, 0)
+	defer second.Close()
+	current.Store(second)
+	server.CloseClientConnections()
+	waitFor(t, "resync", func() bool { return r.Status().PrimaryID == second.ID() })
+	if s := r.Status(); s.FullSyncs != 2 || !sameContent(primary, replica) {
+		t.Error("unexpected status", s)
+	}
+}
+
+func TestBacklogGap(t *testing.T) {
+	p := &Primary{size: 10, notify: make(chan struct{})}
+	for seq := uint64(1); seq <= 3; seq++ {
+		p.record(memory_cache.TableEvent{Seq: seq, Type: memory_cache.EventDeleted, Key: "k"})
+	}
+	if ops, _, behind := p.since(1); behind || len(ops) != 2 {
+		t.Fatal("contiguous backlog should resume", len(ops), behind)
+	}
+	//事件4、5被table丢弃
+	p.record(memory_cache.TableEvent{Seq: 6, Type: memory_cache.EventDeleted, Key: "k"})
+	if _, _, behind := p.since(3); !behind {
+		t.Error("replica should resync after a gap in the event sequence")
+	}
+	if ops, _, behind := p.since(5); behind || len(ops) != 1 || ops[0].Seq != 6 {
+		t.Error("backlog should restart after the gap", ops, behind)
+	}
+}
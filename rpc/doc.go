@@ -0,0 +1,8 @@
+//Package rpc 通过gRPC远程访问Cache(name)注册的表。
+//
+//服务定义见cache.proto，Server是基于注册表的服务端实现。
+//Store是表的读写接口，LocalStore直接包装本地的CacheTable，Client通过gRPC访问远端的表，
+//业务代码面向Store编程即可在本地缓存和远程缓存之间切换。
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cache.proto
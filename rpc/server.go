@@ -0,0 +1,201 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultWatchBuffer = 1024
+
+//基于Cache(name)注册表的gRPC服务端
+type Server struct {
+	UnimplementedCacheServer
+
+	//Watch流的事件缓冲，客户端消费过慢导致缓冲写满时断开该流
+	WatchBuffer int
+}
+
+func NewServer() *Server {
+	return &Server{WatchBuffer: defaultWatchBuffer}
+}
+
+//把服务注册到gRPC服务器上
+func (s *Server) Register(gs *grpc.Server) {
+	RegisterCacheServer(gs, s)
+}
+
+func table(name string) (*memory_cache.CacheTable, error) {
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "table name is required")
+	}
+	return memory_cache.Cache(name), nil
+}
+
+//表中的数据可能是Go进程直接写入的，统一转换为字节串
+func encodeValue(data interface{}) []byte {
+	switch d := data.(type) {
+	case []byte:
+		return d
+	case string:
+		return []byte(d)
+	default:
+		return []byte(fmt.Sprint(d))
+	}
+}
+
+func newItem(item *memory_cache.CacheItem) *Item {
+	return &Item{
+		Key:              fmt.Sprint(item.Key()),
+		Value:            encodeValue(item.Data()),
+		LifeSpanNs:       int64(item.LifeSpan()),
+		CreatedOnUnixMs:  item.CreatedOn().UnixNano() / int64(time.Millisecond),
+		AccessedOnUnixMs: item.AccessedOn().UnixNano() / int64(time.Millisecond),
+		AccessedCount:    item.AccessedCount(),
+	}
+}
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	item, err := t.Value(req.Key)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &GetResponse{Item: newItem(item)}, nil
+}
+
+func (s *Server) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	lifeSpan := time.Duration(req.LifeSpanNs)
+	if req.DefaultLifeSpan {
+		lifeSpan = memory_cache.DefaultExpiration
+	}
+	if err := checkLifeSpan(lifeSpan); err != nil {
+		return nil, err
+	}
+	item, err := t.Put(req.Key, req.Value, lifeSpan)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &SetResponse{Item: newItem(item)}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.Delete(req.Key)
+	if err != nil && err != memory_cache.ErrNotFound {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &DeleteResponse{Deleted: err == nil}, nil
+}
+
+func (s *Server) GetMany(ctx context.Context, req *GetManyRequest) (*GetManyResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	resp := &GetManyResponse{}
+	for _, key := range req.Keys {
+		if item, err := t.Value(key); err == nil {
+			resp.Items = append(resp.Items, newItem(item))
+		}
+	}
+	return resp, nil
+}
+
+func newEvent(event memory_cache.TableEvent) *Event {
+	e := &Event{Seq: event.Seq}
+	switch event.Type {
+	case memory_cache.EventAdded:
+		e.Type = Event_ADDED
+	case memory_cache.EventDeleted:
+		e.Type = Event_DELETED
+	case memory_cache.EventExpired:
+		e.Type = Event_EXPIRED
+	case memory_cache.EventFlushed:
+		e.Type = Event_FLUSHED
+	case memory_cache.EventEvicted:
+		e.Type = Event_EVICTED
+	}
+	if event.Item != nil {
+		e.Key = fmt.Sprint(event.Key)
+		e.Item = newItem(event.Item)
+	}
+	return e
+}
+
+func (s *Server) Watch(req *WatchRequest, stream Cache_WatchServer) error {
+	t, err := table(req.Table)
+	if err != nil {
+		return err
+	}
+	size := s.WatchBuffer
+	if size <= 0 {
+		size = defaultWatchBuffer
+	}
+	events := make(chan *Event, size)
+	overflow := make(chan struct{})
+	cancel := t.Watch(func(event memory_cache.TableEvent) {
+		select {
+		case events <- newEvent(event):
+		default: //不能阻塞表的事件分发，缓冲满了就断开这个流
+			select {
+			case <-overflow:
+			default:
+				close(overflow)
+			}
+		}
+	})
+	defer cancel()
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "watch buffer overflow")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *Server) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	stats := t.Stats()
+	return &StatsResponse{
+		Table:     stats.Name,
+		Count:     int64(stats.Count),
+		Hits:      stats.Hits,
+		Misses:    stats.Misses,
+		Evictions: stats.Evictions,
+	}, nil
+}
+
+func (s *Server) Flush(ctx context.Context, req *FlushRequest) (*FlushResponse, error) {
+	t, err := table(req.Table)
+	if err != nil {
+		return nil, err
+	}
+	t.Flush()
+	return &FlushResponse{}, nil
+}
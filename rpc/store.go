@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//一张表的读写接口，本地和远程的实现行为一致
+//key不存在时Get返回memory_cache.ErrNotFound，Delete不存在的key不是错误
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, lifeSpan time.Duration) error
+	Delete(ctx context.Context, key string) error
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+	//阻塞地把表的事件交给f，直到ctx被取消
+	Watch(ctx context.Context, f func(event memory_cache.TableEvent)) error
+	Stats(ctx context.Context) (memory_cache.TableStats, error)
+	Flush(ctx context.Context) error
+}
+
+var (
+	_ Store = (*LocalStore)(nil)
+	_ Store = (*Client)(nil)
+)
+
+//直接访问本地CacheTable的Store
+type LocalStore struct {
+	table *memory_cache.CacheTable
+}
+
+func NewLocalStore(table *memory_cache.CacheTable) *LocalStore {
+	return &LocalStore{table: table}
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := s.table.Value(key)
+	if err != nil {
+		return nil, memory_cache.ErrNotFound
+	}
+	return encodeValue(item.Data()), nil
+}
+
+//与Client一样返回write-through Writer的错误，lifeSpan为DefaultExpiration以外的负数时返回codes.InvalidArgument
+func (s *LocalStore) Set(ctx context.Context, key string, value []byte, lifeSpan time.Duration) error {
+	if err := checkLifeSpan(lifeSpan); err != nil {
+		return err
+	}
+	_, err := s.table.Put(key, value, lifeSpan)
+	return err
+}
+
+//Server和LocalStore接受相同范围的生命周期
+func checkLifeSpan(lifeSpan time.Duration) error {
+	if lifeSpan < 0 && lifeSpan != memory_cache.DefaultExpiration {
+		return status.Error(codes.InvalidArgument, "life span must not be negative")
+	}
+	return nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.table.Delete(key); err != nil && err != memory_cache.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if item, err := s.table.Value(key); err == nil {
+			values[key] = encodeValue(item.Data())
+		}
+	}
+	return values, nil
+}
+
+//与Server.Watch一样，f处理过慢导致缓冲写满时返回codes.ResourceExhausted，不会阻塞表的事件分发
+func (s *LocalStore) Watch(ctx context.Context, f func(event memory_cache.TableEvent)) error {
+	events := make(chan memory_cache.TableEvent, defaultWatchBuffer)
+	overflow := make(chan struct{})
+	cancel := s.table.Watch(func(event memory_cache.TableEvent) {
+		select {
+		case events <- event:
+		default:
+			select {
+			case <-overflow:
+			default:
+				close(overflow)
+			}
+		}
+	})
+	defer cancel()
+	for {
+		select {
+		case event := <-events:
+			f(event)
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "watch buffer overflow")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *LocalStore) Stats(ctx context.Context) (memory_cache.TableStats, error) {
+	return s.table.Stats(), nil
+}
+
+func (s *LocalStore) Flush(ctx context.Context) error {
+	s.table.Flush()
+	return nil
+}
+
+//通过gRPC访问远端一张表的Store
+type Client struct {
+	client CacheClient
+	table  string
+}
+
+func NewClient(cc grpc.ClientConnInterface, table string) *Client {
+	return &Client{
+		client: NewCacheClient(cc),
+		table:  table,
+	}
+}
+
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.client.Get(ctx, &GetRequest{Table: c.table, Key: key})
+	if status.Code(err) == codes.NotFound {
+		return nil, memory_cache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Item.Value, nil
+}
+
+//lifeSpan为memory_cache.DefaultExpiration时使用远端表的默认生命周期
+func (c *Client) Set(ctx context.Context, key string, value []byte, lifeSpan time.Duration) error {
+	req := &SetRequest{
+		Table:      c.table,
+		Key:        key,
+		Value:      value,
+		LifeSpanNs: int64(lifeSpan),
+	}
+	if lifeSpan == memory_cache.DefaultExpiration {
+		req.LifeSpanNs = 0
+		req.DefaultLifeSpan = true
+	}
+	_, err := c.client.Set(ctx, req)
+	return err
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.client.Delete(ctx, &DeleteRequest{Table: c.table, Key: key})
+	return err
+}
+
+func (c *Client) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	resp, err := c.client.GetMany(ctx, &GetManyRequest{Table: c.table, Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(resp.Items))
+	for _, item := range resp.Items {
+		values[item.Key] = item.Value
+	}
+	return values, nil
+}
+
+//远端的item在本地重建为CacheItem，只携带key、value和生命周期
+func (c *Client) Watch(ctx context.Context, f func(event memory_cache.TableEvent)) error {
+	stream, err := c.client.Watch(ctx, &WatchRequest{Table: c.table})
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF || status.Code(err) == codes.Canceled || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		event := memory_cache.TableEvent{Seq: e.Seq}
+		switch e.Type {
+		case Event_ADDED:
+			event.Type = memory_cache.EventAdded
+		case Event_DELETED:
+			event.Type = memory_cache.EventDeleted
+		case Event_EXPIRED:
+			event.Type = memory_cache.EventExpired
+		case Event_FLUSHED:
+			event.Type = memory_cache.EventFlushed
+		case Event_EVICTED:
+			event.Type = memory_cache.EventEvicted
+		}
+		if e.Item != nil {
+			event.Key = e.Key
+			event.Item = memory_cache.NewCacheItem(e.Key, e.Item.Value, time.Duration(e.Item.LifeSpanNs))
+		}
+		f(event)
+	}
+}
+
+func (c *Client) Stats(ctx context.Context) (memory_cache.TableStats, error) {
+	resp, err := c.client.Stats(ctx, &StatsRequest{Table: c.table})
+	if err != nil {
+		return memory_cache.TableStats{}, err
+	}
+	return memory_cache.TableStats{
+		Name:      resp.Table,
+		Count:     int(resp.Count),
+		Hits:      resp.Hits,
+		Misses:    resp.Misses,
+		Evictions: resp.Evictions,
+	}, nil
+}
+
+func (c *Client) Flush(ctx context.Context) error {
+	_, err := c.client.Flush(ctx, &FlushRequest{Table: c.table})
+	return err
+}
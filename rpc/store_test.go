@@ -0,0 +1,227 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func startServer(t *testing.T) (*grpc.ClientConn, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	NewServer().Register(gs)
+	go gs.Serve(l)
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, func() {
+		cc.Close()
+		gs.Stop()
+	}
+}
+
+//本地和远程的Store应该表现一致
+func testStore(t *testing.T, store Store, table *memory_cache.CacheTable) {
+	ctx := context.Background()
+	before := table.Stats() //Flush不会清零命中计数
+	if _, err := store.Get(ctx, "k"); err != memory_cache.ErrNotFound {
+		t.Error("Get missing key should return ErrNotFound", err)
+	}
+	if err := store.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := store.Get(ctx, "k"); err != nil || string(value) != "v" {
+		t.Error("wrong value", value, err)
+	}
+	if item, _ := table.Peek("k"); item.LifeSpan() != time.Minute {
+		t.Error("wrong life span", item.LifeSpan())
+	}
+	table.SetDefaultLifeSpan(time.Hour)
+	store.Set(ctx, "default", []byte("v"), memory_cache.DefaultExpiration)
+	if item, _ := table.Peek("default"); item.LifeSpan() != time.Hour {
+		t.Error("DefaultExpiration should use table default", item.LifeSpan())
+	}
+	table.Add("local", 42, 0)
+
+	values, err := store.GetMany(ctx, []string{"k", "local", "missing"})
+	if err != nil || len(values) != 2 || string(values["local"]) != "42" {
+		t.Error("wrong GetMany result", values, err)
+	}
+	if err = store.Delete(ctx, "k"); err != nil || table.Exists("k") {
+		t.Error("Delete failed", err)
+	}
+	if err = store.Delete(ctx, "k"); err != nil {
+		t.Error("Delete missing key should not fail", err)
+	}
+	if stats, err := store.Stats(ctx); err != nil || stats.Count != 2 || stats.Misses != before.Misses+2 || stats.Hits != before.Hits+3 {
+		t.Error("wrong stats", stats, err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	events := make(chan memory_cache.TableEvent, 10)
+	done := make(chan error)
+	go func() {
+		done <- store.Watch(wctx, func(event memory_cache.TableEvent) {
+			events <- event
+		})
+	}()
+	time.Sleep(50 * time.Millisecond) //等待订阅建立
+	store.Set(ctx, "w", []byte("1"), 0)
+	store.Delete(ctx, "w")
+	store.Flush(ctx)
+	for _, expected := range []memory_cache.EventType{memory_cache.EventAdded, memory_cache.EventDeleted, memory_cache.EventFlushed} {
+		select {
+		case event := <-events:
+			if event.Type != expected {
+				t.Error("wrong event", event.Type, expected)
+			}
+			if expected == memory_cache.EventAdded && (event.Key != "w" || !bytes.Equal(event.Item.Data().([]byte), []byte("1"))) {
+				t.Error("wrong added event", event.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event", expected)
+		}
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Error("Watch should return nil after cancel", err)
+	}
+	if table.Count() != 0 {
+		t.Error("Flush failed")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	table := memory_cache.Cache("TestLocalStore")
+	table.Flush()
+	testStore(t, NewLocalStore(table), table)
+}
+
+func TestClient(t *testing.T) {
+	cc, stop := startServer(t)
+	defer stop()
+	table := memory_cache.Cache("TestClient")
+	table.Flush()
+	testStore(t, NewClient(cc, "TestClient"), table)
+}
+
+func TestInvalidTable(t *testing.T) {
+	cc, stop := startServer(t)
+	defer stop()
+	if _, err := NewClient(cc, "").Get(context.Background(), "k"); err == nil || err == memory_cache.ErrNotFound {
+		t.Error("empty table name should be rejected", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(ops []memory_cache.WriteOp) error {
+	return errors.New("backend down")
+}
+
+//write-through的错误和淘汰计数在本地和远程一致
+func testStoreBackend(t *testing.T, store Store, table *memory_cache.CacheTable) {
+	ctx := context.Background()
+	table.SetWriteThrough(failingWriter{})
+	if err := store.Set(ctx, "k", []byte("v"), 0); err == nil || table.Exists("k") {
+		t.Error("Set should return the write-through error", err)
+	}
+	table.SetWriteThrough(nil)
+	store.Set(ctx, "k", []byte("v"), 0)
+	table.SetWriteThrough(failingWriter{})
+	if err := store.Delete(ctx, "k"); err == nil || !table.Exists("k") {
+		t.Error("Delete should return the write-through error", err)
+	}
+	if err := store.Delete(ctx, "missing"); err != nil {
+		t.Error("deleting a missing key is not an error", err)
+	}
+	table.SetWriteThrough(nil)
+	store.Delete(ctx, "k")
+	before := table.Stats().Evictions
+	store.Set(ctx, "a", []byte("1"), 0)
+	store.Set(ctx, "b", []byte("2"), 0)
+	if stats, err := store.Stats(ctx); err != nil || stats.Evictions != before+1 {
+		t.Error("Stats should report evictions", stats, err)
+	}
+
+	//生命周期按纳秒传递，毫秒以下不会变成永久有效；负数在两边都被拒绝
+	store.Set(ctx, "short", []byte("1"), 500*time.Microsecond)
+	if item, err := table.Peek("short"); err != nil || item.LifeSpan() != 500*time.Microsecond {
+		t.Error("sub-millisecond life span should be kept", err)
+	}
+	if err := store.Set(ctx, "neg", []byte("1"), -time.Second); status.Code(err) != codes.InvalidArgument {
+		t.Error("negative life span should be rejected", err)
+	}
+	if err := store.Set(ctx, "default", []byte("1"), memory_cache.DefaultExpiration); err != nil {
+		t.Error("DefaultExpiration should be accepted", err)
+	}
+}
+
+func TestLocalStoreBackend(t *testing.T) {
+	table := memory_cache.CacheWithPolicy("TestLocalStoreBackend", memory_cache.NewLRUPolicy(1))
+	table.Flush()
+	testStoreBackend(t, NewLocalStore(table), table)
+}
+
+func TestClientBackend(t *testing.T) {
+	cc, stop := startServer(t)
+	defer stop()
+	table := memory_cache.CacheWithPolicy("TestClientBackend", memory_cache.NewLRUPolicy(1))
+	table.Flush()
+	testStoreBackend(t, NewClient(cc, "TestClientBackend"), table)
+}
+
+func TestLocalStoreSlowWatcher(t *testing.T) {
+	table := memory_cache.Cache("TestLocalStoreSlowWatcher")
+	store := NewLocalStore(table)
+	var received int64
+	cancel := table.Watch(func(event memory_cache.TableEvent) {
+		atomic.AddInt64(&received, 1)
+	})
+	defer cancel()
+
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- store.Watch(context.Background(), func(event memory_cache.TableEvent) {
+			<-block
+		})
+	}()
+	time.Sleep(50 * time.Millisecond) //等待订阅建立
+	n := defaultWatchBuffer + 10
+	for i := 0; i < n; i++ {
+		table.Add("k", i, 0)
+	}
+	//阻塞的watcher不影响其他订阅者
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&received) < int64(n) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&received); got < int64(n) {
+		t.Error("slow watcher should not stall other watchers", got)
+	}
+	//缓冲已经写满，f返回后Watch断开
+	close(block)
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Error("slow watcher should be disconnected", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow watcher was not disconnected")
+	}
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+type command struct {
+	handler func(cn *conn, args [][]byte)
+	arity   int //和redis一致：包含命令名在内的参数个数，负数表示至少-arity个
+}
+
+var commands map[string]command
+
+//在init中初始化，避免handler间接引用commands时的初始化循环
+func init() {
+	commands = map[string]command{
+		"PING":    {ping, -1},
+		"ECHO":    {echo, 2},
+		"QUIT":    {quit, 1},
+		"SELECT":  {selectDB, 2},
+		"HELLO":   {hello, -1},
+		"COMMAND": {commandInfo, -1},
+		"GET":     {get, 2},
+		"SET":     {set, -3},
+		"DEL":     {del, -2},
+		"EXISTS":  {exists, -2},
+		"TTL":     {ttl, 2},
+		"PTTL":    {pttl, 2},
+		"EXPIRE":  {expire, 3},
+		"PEXPIRE": {pexpire, 3},
+		"INCR":    {incr, 2},
+		"DECR":    {decr, 2},
+		"INCRBY":  {incrBy, 3},
+		"DECRBY":  {decrBy, 3},
+		"FLUSHDB": {flushDB, -1},
+		"DBSIZE":  {dbSize, 1},
+		"KEYS":    {keys, 2},
+	}
+}
+
+//表中的数据可能是Go进程直接写入的，统一转换成字节串返回给客户端
+func encodeValue(data interface{}) []byte {
+	switch d := data.(type) {
+	case *MemcacheEntry:
+		return d.Value
+	case []byte:
+		return d
+	case string:
+		return []byte(d)
+	default:
+		return []byte(fmt.Sprint(d))
+	}
+}
+
+func ping(cn *conn, args [][]byte) {
+	switch len(args) {
+	case 0:
+		cn.writer.Simple("PONG")
+	case 1:
+		cn.writer.Bulk(args[0])
+	default:
+		cn.writer.Error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func echo(cn *conn, args [][]byte) {
+	cn.writer.Bulk(args[0])
+}
+
+func quit(cn *conn, args [][]byte) {
+	cn.writer.Simple("OK")
+	cn.quit = true
+}
+
+func selectDB(cn *conn, args [][]byte) {
+	db, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		cn.writer.Error("ERR value is not an integer or out of range")
+		return
+	}
+	if db < 0 || db >= cn.server.Databases {
+		cn.writer.Error("ERR DB index is out of range")
+		return
+	}
+	cn.db = db
+	cn.table = cn.server.table(db)
+	cn.writer.Simple("OK")
+}
+
+//HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(cn *conn, args [][]byte) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			cn.writer.Error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			cn.writer.Error("NOPROTO unsupported protocol version")
+			return
+		}
+		cn.writer.proto = proto
+	}
+	cn.writer.Map(7)
+	cn.writer.Bulk([]byte("server"))
+	cn.writer.Bulk([]byte("memory-cache"))
+	cn.writer.Bulk([]byte("version"))
+	cn.writer.Bulk([]byte("1.0.0"))
+	cn.writer.Bulk([]byte("proto"))
+	cn.writer.Integer(int64(cn.writer.proto))
+	cn.writer.Bulk([]byte("id"))
+	cn.writer.Integer(0)
+	cn.writer.Bulk([]byte("mode"))
+	cn.writer.Bulk([]byte("standalone"))
+	cn.writer.Bulk([]byte("role"))
+	cn.writer.Bulk([]byte("master"))
+	cn.writer.Bulk([]byte("modules"))
+	cn.writer.Array(0)
+}
+
+//redis-cli启动时会查询命令文档，返回空列表即可
+func commandInfo(cn *conn, args [][]byte) {
+	cn.writer.Array(0)
+}
+
+//和redis一样过期时间是绝对的：用Peek读取，不会像Value那样KeepAlive而推迟过期
+func get(cn *conn, args [][]byte) {
+	item, err := cn.table.Peek(string(args[0]))
+	if err != nil || !alive(item) {
+		cn.writer.Null()
+		return
+	}
+	cn.writer.Bulk(encodeValue(item.Data()))
+}
+
+//已经过期但还没有被清理的item当作不存在
+func alive(item *memory_cache.CacheItem) bool {
+	expiresAt := item.ExpiresAt()
+	return expiresAt.IsZero() || time.Now().Before(expiresAt)
+}
+
+//SET key value [NX|XX] [EX seconds|PX milliseconds]
+func set(cn *conn, args [][]byte) {
+	key := string(args[0])
+	value := append([]byte(nil), args[1]...)
+	var lifeSpan time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case (opt == "EX" || opt == "PX") && lifeSpan == 0 && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				cn.writer.Error("ERR value is not an integer or out of range")
+				return
+			}
+			unit := time.Millisecond
+			if opt == "EX" {
+				unit = time.Second
+			}
+			var ok bool
+			if lifeSpan, ok = expireDuration(n, unit); n <= 0 || !ok {
+				cn.writer.Error("ERR invalid expire time in 'set' command")
+				return
+			}
+		default:
+			cn.writer.Error("ERR syntax error")
+			return
+		}
+	}
+
+	switch {
+	case nx:
+		added, err := cn.table.NotFoundPut(key, value, lifeSpan)
+		if err != nil {
+			writeError(cn, err)
+			return
+		}
+		if !added {
+			cn.writer.Null()
+			return
+		}
+	case xx:
+		for {
+			item, err := cn.table.Peek(key)
+			if err != nil {
+				cn.writer.Null()
+				return
+			}
+			_, ok, err := cn.table.CompareAndPut(item, value, lifeSpan)
+			if err != nil {
+				writeError(cn, err)
+				return
+			}
+			if ok {
+				break
+			}
+		}
+	default:
+		if _, err := cn.table.Put(key, value, lifeSpan); err != nil {
+			writeError(cn, err)
+			return
+		}
+	}
+	cn.writer.Simple("OK")
+}
+
+//write-through失败时回复错误，客户端不会把没有持久化的写入当作成功
+func writeError(cn *conn, err error) {
+	cn.writer.Error("ERR %s", err.Error())
+}
+
+func del(cn *conn, args [][]byte) {
+	var n int64
+	for _, key := range args {
+		_, err := cn.table.Delete(string(key))
+		switch err {
+		case nil:
+			n++
+		case memory_cache.ErrNotFound:
+		default:
+			writeError(cn, err)
+			return
+		}
+	}
+	cn.writer.Integer(n)
+}
+
+func exists(cn *conn, args [][]byte) {
+	var n int64
+	for _, key := range args {
+		if cn.table.Exists(string(key)) {
+			n++
+		}
+	}
+	cn.writer.Integer(n)
+}
+
+//剩余生存时间，key不存在返回-2，永久有效返回-1
+func remaining(cn *conn, key string, unit time.Duration) int64 {
+	item, err := cn.table.Peek(key)
+	if err != nil {
+		return -2
+	}
+	expiresAt := item.ExpiresAt()
+	if expiresAt.IsZero() {
+		return -1
+	}
+	left := time.Until(expiresAt)
+	if left < 0 {
+		return -2
+	}
+	return int64((left + unit/2) / unit)
+}
+
+func ttl(cn *conn, args [][]byte) {
+	cn.writer.Integer(remaining(cn, string(args[0]), time.Second))
+}
+
+func pttl(cn *conn, args [][]byte) {
+	cn.writer.Integer(remaining(cn, string(args[0]), time.Millisecond))
+}
+
+func expireWithUnit(cn *conn, args [][]byte, unit time.Duration) {
+	key := string(args[0])
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		cn.writer.Error("ERR value is not an integer or out of range")
+		return
+	}
+	if n <= 0 { //和redis一致，非正数的过期时间直接删除key
+		if _, err = cn.table.Delete(key); err == memory_cache.ErrNotFound {
+			cn.writer.Integer(0)
+			return
+		} else if err != nil {
+			writeError(cn, err)
+			return
+		}
+		cn.writer.Integer(1)
+		return
+	}
+	lifeSpan, ok := expireDuration(n, unit)
+	if !ok {
+		cn.writer.Error("ERR invalid expire time in 'expire' command")
+		return
+	}
+	if _, err = cn.table.Touch(key, lifeSpan); err != nil {
+		cn.writer.Integer(0)
+		return
+	}
+	cn.writer.Integer(1)
+}
+
+//把n个unit转换为生命周期，过期时间(当前时间加上生命周期)溢出int64时返回false
+//乘积溢出时会变成0或负数，0表示永久有效，负数会让key立即过期；同时为表的生命周期抖动留出一倍的余量
+func expireDuration(n int64, unit time.Duration) (time.Duration, bool) {
+	if n > (math.MaxInt64-time.Now().UnixNano())/2/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func expire(cn *conn, args [][]byte) {
+	expireWithUnit(cn, args, time.Second)
+}
+
+func pexpire(cn *conn, args [][]byte) {
+	expireWithUnit(cn, args, time.Millisecond)
+}
+
+//基于CompareAndSwap的无锁自增，并发的INCR不会丢失更新，key的剩余生存时间保持不变
+func addInteger(cn *conn, key string, delta int64) {
+	for {
+		item, err := cn.table.Peek(key)
+		if err == memory_cache.ErrNotFound {
+			added, err := cn.table.NotFoundPut(key, []byte(strconv.FormatInt(delta, 10)), 0)
+			if err != nil {
+				writeError(cn, err)
+				return
+			}
+			if added {
+				cn.writer.Integer(delta)
+				return
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(string(encodeValue(item.Data())), 10, 64)
+		if err != nil {
+			cn.writer.Error("ERR value is not an integer or out of range")
+			return
+		}
+		if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+			cn.writer.Error("ERR increment or decrement would overflow")
+			return
+		}
+		n += delta
+		_, ok, err := cn.table.CompareAndPutData(item, []byte(strconv.FormatInt(n, 10)))
+		if err != nil {
+			writeError(cn, err)
+			return
+		}
+		if ok {
+			cn.writer.Integer(n)
+			return
+		}
+	}
+}
+
+func incr(cn *conn, args [][]byte) {
+	addInteger(cn, string(args[0]), 1)
+}
+
+func decr(cn *conn, args [][]byte) {
+	addInteger(cn, string(args[0]), -1)
+}
+
+func incrBy(cn *conn, args [][]byte) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		cn.writer.Error("ERR value is not an integer or out of range")
+		return
+	}
+	addInteger(cn, string(args[0]), delta)
+}
+
+func decrBy(cn *conn, args [][]byte) {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		cn.writer.Error("ERR value is not an integer or out of range")
+		return
+	}
+	addInteger(cn, string(args[0]), -delta)
+}
+
+//FLUSHDB [ASYNC|SYNC]，表的Flush本身就是O(1)的，两种方式没有区别
+func flushDB(cn *conn, args [][]byte) {
+	cn.table.Flush()
+	cn.writer.Simple("OK")
+}
+
+func dbSize(cn *conn, args [][]byte) {
+	cn.writer.Integer(int64(cn.table.Count()))
+}
+
+func keys(cn *conn, args [][]byte) {
+	pattern := string(args[0])
+	matched := [][]byte{}
+	for it := cn.table.Iterator(); it.Next(); {
+		key := fmt.Sprint(it.Key())
+		if matchPattern(pattern, key) {
+			matched = append(matched, []byte(key))
+		}
+	}
+	cn.writer.Array(len(matched))
+	for _, key := range matched {
+		cn.writer.Bulk(key)
+	}
+}
+
+//redis风格的glob匹配，支持* ? [abc] [^a] [a-z]和\转义
+//与path.Match不同，*可以匹配包括/在内的任意字符
+//除*外每个元素恰好匹配一个字符，失配时只需回到最近的*让它多匹配一个字符，最坏O(len(pattern)*len(s))
+func matchPattern(pattern, s string) bool {
+	var starPattern, starS string //最近一个*之后的pattern，以及这个*之后待匹配的s
+	star := false
+	for {
+		if len(pattern) > 0 && pattern[0] == '*' {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			starPattern, starS, star = pattern, s, true
+			continue
+		}
+		if len(pattern) == 0 && len(s) == 0 {
+			return true
+		}
+		if n, ok := matchOne(pattern, s); ok {
+			pattern, s = pattern[n:], s[1:]
+			continue
+		}
+		if !star || len(starS) == 0 {
+			return false
+		}
+		starS = starS[1:]
+		pattern, s = starPattern, starS
+	}
+}
+
+//用pattern开头的一个元素(非*)匹配s的第一个字符，返回该元素在pattern中的长度
+func matchOne(pattern, s string) (int, bool) {
+	if len(pattern) == 0 || len(s) == 0 {
+		return 0, false
+	}
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 { //没有闭合的[按普通字符处理
+			return 1, s[0] == '['
+		}
+		class := pattern[1 : end+1]
+		negate := len(class) > 0 && class[0] == '^'
+		if negate {
+			class = class[1:]
+		}
+		matched := false
+		for i := 0; i < len(class); i++ {
+			if i+2 < len(class) && class[i+1] == '-' {
+				if class[i] <= s[0] && s[0] <= class[i+2] {
+					matched = true
+				}
+				i += 2
+			} else if class[i] == s[0] {
+				matched = true
+			}
+		}
+		return end + 2, matched != negate
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, s[0] == pattern[1]
+		}
+	}
+	return 1, s[0] == pattern[0]
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+//RESP和memcached前端共用的监听与连接管理
+type listener struct {
+	mu     sync.Mutex
+	l      net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+//在l上接受连接，每个连接一个goroutine交给handle处理，直到close被调用
+func (ln *listener) serve(l net.Listener, handle func(c net.Conn)) error {
+	ln.mu.Lock()
+	if ln.closed {
+		ln.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	ln.l = l
+	ln.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			ln.mu.Lock()
+			closed := ln.closed
+			ln.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !ln.track(c) {
+			c.Close()
+			return ErrServerClosed
+		}
+		ln.wg.Add(1)
+		go func() {
+			defer ln.wg.Done()
+			defer ln.untrack(c)
+			handle(c)
+		}()
+	}
+}
+
+func (ln *listener) addr() net.Addr {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.l == nil {
+		return nil
+	}
+	return ln.l.Addr()
+}
+
+//关闭监听和所有连接，等待连接处理goroutine退出
+func (ln *listener) close() error {
+	ln.mu.Lock()
+	if ln.closed {
+		ln.mu.Unlock()
+		return nil
+	}
+	ln.closed = true
+	var err error
+	if ln.l != nil {
+		err = ln.l.Close()
+	}
+	for c := range ln.conns {
+		c.Close()
+	}
+	ln.mu.Unlock()
+	ln.wg.Wait()
+	return err
+}
+
+func (ln *listener) track(c net.Conn) bool {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.closed {
+		return false
+	}
+	if ln.conns == nil {
+		ln.conns = make(map[net.Conn]struct{})
+	}
+	ln.conns[c] = struct{}{}
+	return true
+}
+
+func (ln *listener) untrack(c net.Conn) {
+	ln.mu.Lock()
+	delete(ln.conns, c)
+	ln.mu.Unlock()
+	c.Close()
+}
+
+//当前连接数
+func (ln *listener) count() int {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	return len(ln.conns)
+}
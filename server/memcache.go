@@ -0,0 +1,530 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//memcached ASCII协议前端，所有命令作用在同一张CacheTable上
+
+const (
+	memcacheMaxKeyLen   = 250
+	memcacheMaxValueLen = 1 << 20
+	memcacheRelativeExp = 60 * 60 * 24 * 30 //超过30天的exptime按unix时间戳处理
+)
+
+//memcached写入的数据，作为CacheItem的data保存
+//每次写入都会生成新的MemcacheEntry，并分配新的CAS值
+type MemcacheEntry struct {
+	Value []byte
+	Flags uint32
+	CAS   uint64
+}
+
+//由前端维护的统计计数，stats命令输出
+type memcacheStats struct {
+	totalConnections uint64
+	cmdGet           uint64
+	cmdSet           uint64
+	cmdFlush         uint64
+	cmdTouch         uint64
+	getHits          uint64
+	getMisses        uint64
+	deleteHits       uint64
+	deleteMisses     uint64
+	incrHits         uint64
+	incrMisses       uint64
+	decrHits         uint64
+	decrMisses       uint64
+	casHits          uint64
+	casMisses        uint64
+	casBadval        uint64
+	touchHits        uint64
+	touchMisses      uint64
+}
+
+type MemcacheServer struct {
+	Table  *memory_cache.CacheTable
+	Logger *log.Logger //为nil时不输出日志
+
+	ln      listener
+	cas     uint64
+	started time.Time
+	stats   memcacheStats
+}
+
+func NewMemcacheServer(table *memory_cache.CacheTable) *MemcacheServer {
+	return &MemcacheServer{
+		Table:   table,
+		started: time.Now(),
+	}
+}
+
+func (s *MemcacheServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *MemcacheServer) Serve(l net.Listener) error {
+	return s.ln.serve(l, s.serveConn)
+}
+
+func (s *MemcacheServer) Addr() net.Addr {
+	return s.ln.addr()
+}
+
+func (s *MemcacheServer) Close() error {
+	return s.ln.close()
+}
+
+func (s *MemcacheServer) log(v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Println(v...)
+	}
+}
+
+func (s *MemcacheServer) newEntry(value []byte, flags uint32) *MemcacheEntry {
+	return &MemcacheEntry{
+		Value: value,
+		Flags: flags,
+		CAS:   atomic.AddUint64(&s.cas, 1),
+	}
+}
+
+//Go进程直接写入的数据没有flags和CAS值
+func toEntry(data interface{}) *MemcacheEntry {
+	if entry, ok := data.(*MemcacheEntry); ok {
+		return entry
+	}
+	return &MemcacheEntry{Value: encodeValue(data)}
+}
+
+//把exptime转换为生命周期，已经过期时返回false
+func expiration(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, true
+	case exptime < 0:
+		return 0, false
+	case exptime > memcacheRelativeExp:
+		lifeSpan := time.Until(time.Unix(exptime, 0))
+		return lifeSpan, lifeSpan > 0
+	default:
+		return time.Duration(exptime) * time.Second, true
+	}
+}
+
+type memcacheConn struct {
+	server *MemcacheServer
+	r      *bufio.Reader
+	w      *bufio.Writer
+}
+
+func (s *MemcacheServer) serveConn(c net.Conn) {
+	atomic.AddUint64(&s.stats.totalConnections, 1)
+	mc := &memcacheConn{
+		server: s,
+		r:      bufio.NewReader(c),
+		w:      bufio.NewWriter(c),
+	}
+	for {
+		line, err := mc.r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				s.log("Connection from ", c.RemoteAddr(), " closed: ", err)
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			mc.w.WriteString("ERROR\r\n")
+		} else if !mc.dispatch(fields) {
+			mc.w.Flush()
+			return
+		}
+		if mc.r.Buffered() == 0 {
+			if err = mc.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//处理一条命令，返回false时关闭连接
+func (mc *memcacheConn) dispatch(fields []string) bool {
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		mc.get(args, cmd == "gets")
+	case "set", "add", "replace", "cas":
+		return mc.store(cmd, args)
+	case "delete":
+		mc.delete(args)
+	case "incr", "decr":
+		mc.incr(args, cmd == "incr")
+	case "touch":
+		mc.touch(args)
+	case "flush_all":
+		mc.flushAll(args)
+	case "stats":
+		mc.writeStats(args)
+	case "version":
+		mc.w.WriteString("VERSION memory-cache-1.0.0\r\n")
+	case "verbosity":
+		mc.reply(args, "OK")
+	case "quit":
+		return false
+	default:
+		mc.w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+//最后一个参数是noreply时不输出回复
+func (mc *memcacheConn) reply(args []string, msg string) {
+	if len(args) > 0 && args[len(args)-1] == "noreply" {
+		return
+	}
+	mc.w.WriteString(msg + "\r\n")
+}
+
+func (mc *memcacheConn) clientError(msg string) {
+	mc.w.WriteString("CLIENT_ERROR " + msg + "\r\n")
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > memcacheMaxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+//get <key>*
+//gets <key>*
+func (mc *memcacheConn) get(keys []string, withCAS bool) {
+	s := mc.server
+	if len(keys) == 0 {
+		mc.w.WriteString("ERROR\r\n")
+		return
+	}
+	for _, key := range keys {
+		atomic.AddUint64(&s.stats.cmdGet, 1)
+		item, err := s.Table.Value(key)
+		if err != nil {
+			atomic.AddUint64(&s.stats.getMisses, 1)
+			continue
+		}
+		atomic.AddUint64(&s.stats.getHits, 1)
+		entry := toEntry(item.Data())
+		if withCAS {
+			fmt.Fprintf(mc.w, "VALUE %s %d %d %d\r\n", key, entry.Flags, len(entry.Value), entry.CAS)
+		} else {
+			fmt.Fprintf(mc.w, "VALUE %s %d %d\r\n", key, entry.Flags, len(entry.Value))
+		}
+		mc.w.Write(entry.Value)
+		mc.w.WriteString("\r\n")
+	}
+	mc.w.WriteString("END\r\n")
+}
+
+//<set|add|replace> <key> <flags> <exptime> <bytes> [noreply]
+//cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (mc *memcacheConn) store(cmd string, args []string) bool {
+	s := mc.server
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) < n || len(args) > n+1 || len(args) == n+1 && args[n] != "noreply" {
+		mc.w.WriteString("ERROR\r\n")
+		return true
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	var casUnique uint64
+	var err4 error
+	if cmd == "cas" {
+		casUnique, err4 = strconv.ParseUint(args[4], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		mc.clientError("bad command line format")
+		return true
+	}
+	if size > memcacheMaxValueLen {
+		mc.clientError("object too large for cache")
+		//丢弃数据块，无法同步时关闭连接
+		_, err := io.CopyN(io.Discard, mc.r, int64(size)+2)
+		return err == nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(mc.r, data); err != nil {
+		return false
+	}
+	if string(data[size:]) != "\r\n" {
+		if data[size+1] != '\n' { //数据比声明的长，丢弃本行剩余部分
+			if _, err := mc.r.ReadString('\n'); err != nil {
+				return false
+			}
+		}
+		mc.clientError("bad data chunk")
+		return true
+	}
+	if !validKey(key) {
+		mc.clientError("bad command line format")
+		return true
+	}
+	atomic.AddUint64(&s.stats.cmdSet, 1)
+
+	entry := s.newEntry(data[:size], uint32(flags))
+	lifeSpan, alive := expiration(exptime)
+	var result string
+	switch cmd {
+	case "set":
+		var err error
+		if alive {
+			_, err = s.Table.Put(key, entry, lifeSpan)
+		} else if _, err = s.Table.Delete(key); err == memory_cache.ErrNotFound {
+			err = nil
+		}
+		result = storeResult(err, "STORED")
+	case "add":
+		result = "NOT_STORED"
+		if alive {
+			added, err := s.Table.NotFoundPut(key, entry, lifeSpan)
+			if added {
+				result = "STORED"
+			}
+			result = storeResult(err, result)
+		}
+	case "replace":
+		result = mc.replace(key, entry, lifeSpan, alive, func(cur *MemcacheEntry) bool {
+			return true
+		})
+		if result == "NOT_FOUND" {
+			result = "NOT_STORED"
+		}
+	case "cas":
+		result = mc.replace(key, entry, lifeSpan, alive, func(cur *MemcacheEntry) bool {
+			return cur.CAS == casUnique
+		})
+		switch result {
+		case "STORED":
+			atomic.AddUint64(&s.stats.casHits, 1)
+		case "EXISTS":
+			atomic.AddUint64(&s.stats.casBadval, 1)
+		case "NOT_FOUND":
+			atomic.AddUint64(&s.stats.casMisses, 1)
+		}
+	}
+	mc.reply(args, result)
+	return true
+}
+
+//key存在且match返回true时用entry替换当前值，检查和替换通过CompareAndSwap保证原子性
+func (mc *memcacheConn) replace(key string, entry *MemcacheEntry, lifeSpan time.Duration, alive bool, match func(cur *MemcacheEntry) bool) string {
+	table := mc.server.Table
+	for {
+		item, err := table.Peek(key)
+		if err != nil {
+			return "NOT_FOUND"
+		}
+		if !match(toEntry(item.Data())) {
+			return "EXISTS"
+		}
+		if !alive {
+			if _, err := table.Delete(key); err != memory_cache.ErrNotFound {
+				return storeResult(err, "STORED")
+			}
+			continue //并发删除了，重新检查
+		}
+		if _, ok, err := table.CompareAndPut(item, entry, lifeSpan); ok || err != nil {
+			return storeResult(err, "STORED")
+		}
+	}
+}
+
+//write-through失败时回复SERVER_ERROR，否则回复result
+func storeResult(err error, result string) string {
+	if err != nil {
+		return "SERVER_ERROR " + err.Error()
+	}
+	return result
+}
+
+//delete <key> [noreply]
+func (mc *memcacheConn) delete(args []string) {
+	s := mc.server
+	if len(args) < 1 || len(args) > 2 {
+		mc.w.WriteString("ERROR\r\n")
+		return
+	}
+	if _, err := s.Table.Delete(args[0]); err == memory_cache.ErrNotFound {
+		atomic.AddUint64(&s.stats.deleteMisses, 1)
+		mc.reply(args, "NOT_FOUND")
+		return
+	} else if err != nil {
+		mc.reply(args, storeResult(err, ""))
+		return
+	}
+	atomic.AddUint64(&s.stats.deleteHits, 1)
+	mc.reply(args, "DELETED")
+}
+
+//incr <key> <value> [noreply]
+//decr <key> <value> [noreply]
+//和memcached一致，incr按64位无符号数回绕，decr最小减到0
+func (mc *memcacheConn) incr(args []string, incr bool) {
+	s := mc.server
+	if len(args) < 2 || len(args) > 3 {
+		mc.w.WriteString("ERROR\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		mc.clientError("invalid numeric delta argument")
+		return
+	}
+	hits, misses := &s.stats.incrHits, &s.stats.incrMisses
+	if !incr {
+		hits, misses = &s.stats.decrHits, &s.stats.decrMisses
+	}
+	for {
+		item, err := s.Table.Peek(args[0])
+		if err != nil {
+			atomic.AddUint64(misses, 1)
+			mc.reply(args, "NOT_FOUND")
+			return
+		}
+		cur := toEntry(item.Data())
+		n, err := strconv.ParseUint(string(cur.Value), 10, 64)
+		if err != nil {
+			mc.clientError("cannot increment or decrement non-numeric value")
+			return
+		}
+		if incr {
+			n += delta
+		} else if n < delta {
+			n = 0
+		} else {
+			n -= delta
+		}
+		value := strconv.FormatUint(n, 10)
+		_, ok, err := s.Table.CompareAndPutData(item, s.newEntry([]byte(value), cur.Flags))
+		if err != nil {
+			mc.reply(args, storeResult(err, ""))
+			return
+		}
+		if ok {
+			atomic.AddUint64(hits, 1)
+			mc.reply(args, value)
+			return
+		}
+	}
+}
+
+//touch <key> <exptime> [noreply]
+func (mc *memcacheConn) touch(args []string) {
+	s := mc.server
+	if len(args) < 2 || len(args) > 3 {
+		mc.w.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		mc.clientError("invalid exptime argument")
+		return
+	}
+	atomic.AddUint64(&s.stats.cmdTouch, 1)
+	lifeSpan, alive := expiration(exptime)
+	if alive {
+		_, err = s.Table.Touch(args[0], lifeSpan)
+	} else if _, err = s.Table.Delete(args[0]); err != nil && err != memory_cache.ErrNotFound {
+		mc.reply(args, storeResult(err, ""))
+		return
+	}
+	if err != nil {
+		atomic.AddUint64(&s.stats.touchMisses, 1)
+		mc.reply(args, "NOT_FOUND")
+		return
+	}
+	atomic.AddUint64(&s.stats.touchHits, 1)
+	mc.reply(args, "TOUCHED")
+}
+
+//flush_all [delay] [noreply]
+func (mc *memcacheConn) flushAll(args []string) {
+	s := mc.server
+	atomic.AddUint64(&s.stats.cmdFlush, 1)
+	if len(args) > 0 && args[0] != "noreply" {
+		delay, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			mc.clientError("bad command line format")
+			return
+		}
+		if delay > 0 {
+			time.AfterFunc(time.Duration(delay)*time.Second, s.Table.Flush)
+			mc.reply(args, "OK")
+			return
+		}
+	}
+	s.Table.Flush()
+	mc.reply(args, "OK")
+}
+
+func (mc *memcacheConn) writeStats(args []string) {
+	if len(args) > 0 { //只支持通用统计
+		mc.w.WriteString("END\r\n")
+		return
+	}
+	s := mc.server
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(mc.w, "STAT %s %v\r\n", name, value)
+	}
+	load := atomic.LoadUint64
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.started)/time.Second))
+	stat("time", now.Unix())
+	stat("version", "memory-cache-1.0.0")
+	stat("curr_connections", s.ln.count())
+	stat("total_connections", load(&s.stats.totalConnections))
+	stat("cmd_get", load(&s.stats.cmdGet))
+	stat("cmd_set", load(&s.stats.cmdSet))
+	stat("cmd_flush", load(&s.stats.cmdFlush))
+	stat("cmd_touch", load(&s.stats.cmdTouch))
+	stat("get_hits", load(&s.stats.getHits))
+	stat("get_misses", load(&s.stats.getMisses))
+	stat("delete_misses", load(&s.stats.deleteMisses))
+	stat("delete_hits", load(&s.stats.deleteHits))
+	stat("incr_misses", load(&s.stats.incrMisses))
+	stat("incr_hits", load(&s.stats.incrHits))
+	stat("decr_misses", load(&s.stats.decrMisses))
+	stat("decr_hits", load(&s.stats.decrHits))
+	stat("cas_misses", load(&s.stats.casMisses))
+	stat("cas_hits", load(&s.stats.casHits))
+	stat("cas_badval", load(&s.stats.casBadval))
+	stat("touch_hits", load(&s.stats.touchHits))
+	stat("touch_misses", load(&s.stats.touchMisses))
+	stat("curr_items", s.Table.Count())
+	mc.w.WriteString("END\r\n")
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+type memcacheClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcache(t *testing.T, name string) (*MemcacheServer, *memcacheClient) {
+	table := memory_cache.Cache(name)
+	table.Flush() //表是全局注册的，清空上一次运行(-count)留下的数据
+	s := NewMemcacheServer(table)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &memcacheClient{conn: c, r: bufio.NewReader(c)}
+}
+
+//发送一条命令，读取回复直到出现以end开头的行
+func (c *memcacheClient) do(cmd string, end string) []string {
+	fmt.Fprint(c.conn, cmd+"\r\n")
+	lines := []string{}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return append(lines, err.Error())
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if strings.HasPrefix(line, end) || end == "" {
+			return lines
+		}
+	}
+}
+
+func (c *memcacheClient) one(cmd string) string {
+	return c.do(cmd, "")[0]
+}
+
+func TestMemcacheStorage(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheStorage")
+	defer s.Close()
+
+	expect(t, c.one("set k 5 0 5\r\nhello"), "STORED")
+	expect(t, c.do("get k", "END"), []string{"VALUE k 5 5", "hello", "END"})
+	expect(t, c.one("add k 0 0 1\r\nx"), "NOT_STORED")
+	expect(t, c.one("add k2 0 0 1\r\nx"), "STORED")
+	expect(t, c.one("replace k3 0 0 1\r\nx"), "NOT_STORED")
+	expect(t, c.one("replace k2 7 0 1\r\ny"), "STORED")
+	expect(t, c.do("get k k2 missing", "END"), []string{"VALUE k 5 5", "hello", "VALUE k2 7 1", "y", "END"})
+	expect(t, c.one("delete k2"), "DELETED")
+	expect(t, c.one("delete k2"), "NOT_FOUND")
+	expect(t, c.one("set k 0 0 1\r\nxyz"), "CLIENT_ERROR bad data chunk")
+
+	//noreply不输出回复，下一条命令的回复紧随其后
+	expect(t, c.do("set quiet 0 0 1 noreply\r\nq\r\nget quiet", "END"), []string{"VALUE quiet 0 1", "q", "END"})
+	expect(t, c.one("version"), "VERSION memory-cache-1.0.0")
+	expect(t, c.one("bogus"), "ERROR")
+}
+
+func TestMemcacheCAS(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheCAS")
+	defer s.Close()
+
+	expect(t, c.one("cas k 0 0 1 1\r\nx"), "NOT_FOUND")
+	c.one("set k 0 0 1\r\na")
+	var cas uint64
+	fmt.Sscanf(c.do("gets k", "END")[0], "VALUE k 0 1 %d", &cas)
+	if cas == 0 {
+		t.Fatal("gets should return cas unique")
+	}
+	expect(t, c.one(fmt.Sprintf("cas k 0 0 1 %d\r\nb", cas)), "STORED")
+	expect(t, c.one(fmt.Sprintf("cas k 0 0 1 %d\r\nc", cas)), "EXISTS")
+	expect(t, c.do("get k", "END"), []string{"VALUE k 0 1", "b", "END"})
+}
+
+func TestMemcacheCounters(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheCounters")
+	defer s.Close()
+
+	expect(t, c.one("incr n 1"), "NOT_FOUND")
+	c.one("set n 3 0 2\r\n10")
+	expect(t, c.one("incr n 5"), "15")
+	expect(t, c.one("decr n 20"), "0")
+	expect(t, c.do("get n", "END"), []string{"VALUE n 3 1", "0", "END"})
+	c.one("set s 0 0 1\r\na")
+	expect(t, c.one("incr s 1"), "CLIENT_ERROR cannot increment or decrement non-numeric value")
+}
+
+func TestMemcacheExpiration(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheExpiration")
+	defer s.Close()
+
+	c.one("set k 0 1 1\r\na")
+	c.one("set gone 0 -1 1\r\na")
+	expect(t, c.one("get gone"), "END")
+	expect(t, c.one("touch missing 10"), "NOT_FOUND")
+	expect(t, c.one("touch k 100"), "TOUCHED")
+	item, _ := s.Table.Peek("k")
+	if item.LifeSpan() != 100*time.Second {
+		t.Error("touch should update life span", item.LifeSpan())
+	}
+	c.one(fmt.Sprintf("set abs 0 %d 1\r\na", time.Now().Add(time.Hour).Unix()))
+	if item, _ = s.Table.Peek("abs"); item.LifeSpan() < 59*time.Minute || item.LifeSpan() > time.Hour {
+		t.Error("absolute exptime should be converted to life span", item.LifeSpan())
+	}
+
+	expect(t, c.one("flush_all"), "OK")
+	expect(t, c.one("get k"), "END")
+}
+
+func TestMemcacheStats(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheStats")
+	defer s.Close()
+
+	c.one("set k 0 0 1\r\na")
+	c.do("get k", "END")
+	c.do("get k missing", "END")
+	stats := make(map[string]string)
+	for _, line := range c.do("stats", "END") {
+		var name, value string
+		if n, _ := fmt.Sscanf(line, "STAT %s %s", &name, &value); n == 2 {
+			stats[name] = value
+		}
+	}
+	expect(t, stats["cmd_get"], "3")
+	expect(t, stats["get_hits"], "2")
+	expect(t, stats["get_misses"], "1")
+	expect(t, stats["cmd_set"], "1")
+	expect(t, stats["curr_items"], "1")
+	expect(t, stats["curr_connections"], "1")
+}
+
+//memcached写入的数据通过RESP读取时只返回value
+func TestMemcacheEntryOverRESP(t *testing.T) {
+	s, c := startServer(t, "TestMemcacheEntryOverRESP")
+	defer s.Close()
+	memory_cache.Cache("TestMemcacheEntryOverRESP0").Add("k", &MemcacheEntry{Value: []byte("v"), Flags: 1}, 0)
+	expect(t, c.do("GET", "k"), "v")
+}
+
+func TestMemcacheWriteThroughError(t *testing.T) {
+	s, c := startMemcache(t, "TestMemcacheWriteThroughError")
+	defer s.Close()
+	expect(t, c.one("set k 0 0 1\r\n1"), "STORED")
+	s.Table.SetWriteThrough(failingWriter{})
+	defer s.Table.SetWriteThrough(nil)
+
+	expect(t, c.one("set k 0 0 1\r\n2"), "SERVER_ERROR backend down")
+	expect(t, c.one("add k2 0 0 1\r\n2"), "SERVER_ERROR backend down")
+	expect(t, c.one("replace k 0 0 1\r\n2"), "SERVER_ERROR backend down")
+	expect(t, c.one("incr k 1"), "SERVER_ERROR backend down")
+	expect(t, c.one("delete k"), "SERVER_ERROR backend down")
+	expect(t, c.one("delete missing"), "NOT_FOUND")
+	expect(t, c.do("get k", "END"), []string{"VALUE k 0 1", "1", "END"})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//RESP协议的编解码
+//请求既支持redis客户端使用的多条批量字符串数组，也支持telnet直接输入的inline命令
+
+var errProtocol = errors.New("Protocol error")
+
+const (
+	maxBulkLen      = 512 << 20 //和redis一样，单个参数最大512MB
+	bulkPreallocLen = 64 << 10  //不超过该长度的参数按声明的长度预先分配
+)
+
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+func (rr *respReader) readLine() (string, error) {
+	line, err := rr.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//读取一条命令，返回命令及其参数
+func (rr *respReader) ReadCommand() ([][]byte, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' { //inline命令
+		fields := strings.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, field := range fields {
+			args[i] = []byte(field)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1024*1024 {
+		return nil, errProtocol
+	}
+	if n <= 0 { //和redis一样，*-1和*0当作空命令忽略
+		return nil, nil
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = rr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errProtocol
+		}
+		//声明的长度不可信，大的参数随着数据的到达逐步扩容，不按声明的长度一次性分配
+		var buf bytes.Buffer
+		if size <= bulkPreallocLen {
+			buf.Grow(size + 2)
+		}
+		if _, err = io.CopyN(&buf, rr.r, int64(size)+2); err != nil {
+			return nil, err
+		}
+		args = append(args, buf.Bytes()[:size])
+	}
+	return args, nil
+}
+
+//按连接协商的协议版本(2或3)编码回复
+type respWriter struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w), proto: 2}
+}
+
+func (rw *respWriter) Simple(s string) {
+	rw.w.WriteString("+" + s + "\r\n")
+}
+
+func (rw *respWriter) Error(format string, args ...interface{}) {
+	rw.w.WriteString("-" + fmt.Sprintf(format, args...) + "\r\n")
+}
+
+func (rw *respWriter) Integer(n int64) {
+	rw.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (rw *respWriter) Bulk(b []byte) {
+	rw.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	rw.w.Write(b)
+	rw.w.WriteString("\r\n")
+}
+
+func (rw *respWriter) Null() {
+	if rw.proto == 3 {
+		rw.w.WriteString("_\r\n")
+		return
+	}
+	rw.w.WriteString("$-1\r\n")
+}
+
+func (rw *respWriter) Array(n int) {
+	rw.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+//RESP2没有map类型，退化为key value交替的数组
+func (rw *respWriter) Map(n int) {
+	if rw.proto == 3 {
+		rw.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	rw.Array(2 * n)
+}
+
+func (rw *respWriter) Flush() error {
+	return rw.w.Flush()
+}
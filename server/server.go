@@ -0,0 +1,133 @@
+//Package server 在TCP上对外提供CacheTable，使非Go的服务可以和Go进程共享缓存。
+//
+//Server实现Redis的RESP2/RESP3协议，redis-cli和常见的redis客户端可以直接连接使用。
+//每个redis的数据库编号(SELECT n)对应一张Cache(TablePrefix+n)表，
+//默认第0号数据库对应Cache("db0")，Go进程内可以通过同名的表与外部服务共享数据。
+//与redis一致，EX/PX设置的过期时间是绝对的：GET通过Peek读取，不会像CacheTable.Value那样延长item的生命周期，
+//因此也不计入表的命中统计和淘汰策略的访问记录。
+//
+//MemcacheServer实现memcached的ASCII协议，所有命令作用在同一张表上。
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const (
+	DefaultTablePrefix = "db"
+	DefaultDatabases   = 16
+)
+
+var ErrServerClosed = errors.New("server: Server closed")
+
+type Server struct {
+	TablePrefix string      //数据库编号到表名的前缀
+	Databases   int         //可以SELECT的数据库个数
+	Logger      *log.Logger //为nil时不输出日志
+
+	ln listener
+}
+
+func NewServer() *Server {
+	return &Server{
+		TablePrefix: DefaultTablePrefix,
+		Databases:   DefaultDatabases,
+	}
+}
+
+//监听addr并开始服务，直到Close被调用
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+//在l上接受连接，每个连接一个goroutine处理
+func (s *Server) Serve(l net.Listener) error {
+	return s.ln.serve(l, s.serveConn)
+}
+
+//监听的地址，Serve之前返回nil
+func (s *Server) Addr() net.Addr {
+	return s.ln.addr()
+}
+
+//关闭监听和所有连接，等待连接处理goroutine退出
+func (s *Server) Close() error {
+	return s.ln.close()
+}
+
+func (s *Server) table(db int) *memory_cache.CacheTable {
+	return memory_cache.Cache(s.TablePrefix + strconv.Itoa(db))
+}
+
+func (s *Server) log(v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Println(v...)
+	}
+}
+
+//单个客户端连接的状态
+type conn struct {
+	server *Server
+	reader *respReader
+	writer *respWriter
+	db     int
+	table  *memory_cache.CacheTable
+	quit   bool
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	cn := &conn{
+		server: s,
+		reader: newRespReader(c),
+		writer: newRespWriter(c),
+		table:  s.table(0),
+	}
+	for !cn.quit {
+		args, err := cn.reader.ReadCommand()
+		if err != nil {
+			if err == errProtocol {
+				cn.writer.Error("ERR Protocol error")
+				cn.writer.Flush()
+			}
+			if err != io.EOF && err != errProtocol {
+				s.log("Connection from ", c.RemoteAddr(), " closed: ", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cn.dispatch(args)
+		//管道中还有未读的命令时合并回复，减少系统调用
+		if cn.reader.r.Buffered() == 0 || cn.quit {
+			if err = cn.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (cn *conn) dispatch(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		cn.writer.Error("ERR unknown command '%s'", args[0])
+		return
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		cn.writer.Error("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+		return
+	}
+	cmd.handler(cn, args[1:])
+}
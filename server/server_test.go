@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, prefix string) (*Server, *testClient) {
+	s := NewServer()
+	s.TablePrefix = prefix
+	//表是全局注册的，清空上一次运行(-count)留下的数据
+	for db := 0; db < s.Databases; db++ {
+		s.table(db).Flush()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return s, dial(t, l.Addr().String())
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *testClient) do(args ...string) interface{} {
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.read()
+}
+
+//按RESP解析一个回复，错误返回error类型，空值返回nil
+func (c *testClient) read() interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		io.ReadFull(c.r, buf)
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		if n < 0 {
+			return nil
+		}
+		values := []interface{}{}
+		for i := 0; i < n; i++ {
+			values = append(values, c.read())
+		}
+		return values
+	}
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+func expect(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	s, c := startServer(t, "TestStringCommands")
+	defer s.Close()
+
+	expect(t, c.do("PING"), "PONG")
+	expect(t, c.do("GET", "k"), nil)
+	expect(t, c.do("SET", "k", "v"), "OK")
+	expect(t, c.do("GET", "k"), "v")
+	expect(t, c.do("SET", "k", "v2", "NX"), nil)
+	expect(t, c.do("SET", "k2", "v2", "XX"), nil)
+	expect(t, c.do("SET", "k", "v3", "XX"), "OK")
+	expect(t, c.do("GET", "k"), "v3")
+	expect(t, c.do("EXISTS", "k", "k2", "k"), int64(2))
+	expect(t, c.do("DEL", "k", "k2"), int64(1))
+	expect(t, c.do("DBSIZE"), int64(0))
+
+	expect(t, c.do("INCR", "n"), int64(1))
+	expect(t, c.do("INCRBY", "n", "10"), int64(11))
+	expect(t, c.do("DECR", "n"), int64(10))
+	c.do("SET", "s", "abc")
+	if _, ok := c.do("INCR", "s").(error); !ok {
+		t.Error("INCR on non integer should fail")
+	}
+	if _, ok := c.do("SET", "k", "v", "EX", "0").(error); !ok {
+		t.Error("SET with invalid expire time should fail")
+	}
+	if _, ok := c.do("NOSUCHCOMMAND").(error); !ok {
+		t.Error("unknown command should fail")
+	}
+	if _, ok := c.do("GET").(error); !ok {
+		t.Error("GET without key should fail")
+	}
+
+	//Go进程中写入同一张表的数据对客户端可见
+	memory_cache.Cache("TestStringCommands0").Add("local", 42, 0)
+	expect(t, c.do("GET", "local"), "42")
+}
+
+func TestExpireCommands(t *testing.T) {
+	s, c := startServer(t, "TestExpireCommands")
+	defer s.Close()
+
+	expect(t, c.do("SET", "k", "v", "PX", "50"), "OK")
+	if ttl := c.do("PTTL", "k").(int64); ttl <= 0 || ttl > 50 {
+		t.Error("wrong PTTL", ttl)
+	}
+	c.do("SET", "forever", "v")
+	expect(t, c.do("TTL", "forever"), int64(-1))
+	expect(t, c.do("TTL", "missing"), int64(-2))
+	expect(t, c.do("EXPIRE", "forever", "100"), int64(1))
+	expect(t, c.do("TTL", "forever"), int64(100))
+	expect(t, c.do("EXPIRE", "missing", "100"), int64(0))
+
+	time.Sleep(100 * time.Millisecond)
+	expect(t, c.do("GET", "k"), nil)
+	expect(t, c.do("EXPIRE", "forever", "0"), int64(1))
+	expect(t, c.do("EXISTS", "forever"), int64(0))
+
+	//溢出的过期时间被拒绝，而不是变成永久有效或立即过期
+	c.do("SET", "big", "v")
+	expect(t, c.do("EXPIRE", "big", "9223372036854775807"), fmt.Errorf("ERR invalid expire time in 'expire' command"))
+	expect(t, c.do("PEXPIRE", "big", "9223372036854775"), fmt.Errorf("ERR invalid expire time in 'expire' command"))
+	expect(t, c.do("SET", "big", "v2", "EX", "9223372036854775807"), fmt.Errorf("ERR invalid expire time in 'set' command"))
+	expect(t, c.do("GET", "big"), "v")
+	expect(t, c.do("TTL", "big"), int64(-1))
+
+	//GET不会延长过期时间
+	expect(t, c.do("SET", "read", "v", "PX", "100"), "OK")
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		c.do("GET", "read")
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(t, c.do("GET", "read"), nil)
+}
+
+func TestDatabases(t *testing.T) {
+	s, c := startServer(t, "TestDatabases")
+	defer s.Close()
+
+	c.do("SET", "user:1", "a")
+	c.do("SET", "user:2", "b")
+	c.do("SET", "order:1", "c")
+	expect(t, c.do("SELECT", "1"), "OK")
+	expect(t, c.do("DBSIZE"), int64(0))
+	c.do("SET", "user:3", "d")
+	expect(t, c.do("SELECT", "0"), "OK")
+	keys := c.do("KEYS", "user:*").([]interface{})
+	if len(keys) != 2 || keys[0] == keys[1] || !strings.HasPrefix(keys[0].(string), "user:") {
+		t.Error("wrong KEYS result", keys)
+	}
+	if keys := c.do("KEYS", "*").([]interface{}); len(keys) != 3 {
+		t.Error("wrong KEYS result", keys)
+	}
+	if _, ok := c.do("SELECT", "16").(error); !ok {
+		t.Error("SELECT out of range should fail")
+	}
+	expect(t, c.do("FLUSHDB"), "OK")
+	expect(t, c.do("DBSIZE"), int64(0))
+	if memory_cache.Cache("TestDatabases1").Count() != 1 {
+		t.Error("FLUSHDB should only flush the selected database")
+	}
+}
+
+func TestHelloAndInline(t *testing.T) {
+	s, c := startServer(t, "TestHelloAndInline")
+	defer s.Close()
+
+	reply := c.do("HELLO", "3").([]interface{})
+	if reply[0] != "server" || reply[5] != int64(3) {
+		t.Error("wrong HELLO reply", reply)
+	}
+	expect(t, c.do("GET", "missing"), nil)
+	if _, ok := c.do("HELLO", "4").(error); !ok {
+		t.Error("HELLO with unsupported protocol should fail")
+	}
+
+	fmt.Fprintf(c.conn, "SET inline value\r\nGET inline\r\n")
+	expect(t, c.read(), "OK")
+	expect(t, c.read(), "value")
+	expect(t, c.do("QUIT"), "OK")
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Error("connection should be closed after QUIT", err)
+	}
+}
+
+func TestNegativeMultibulk(t *testing.T) {
+	s, c := startServer(t, "TestNegativeMultibulk")
+	defer s.Close()
+
+	//负数和0个参数的数组被忽略，连接仍然可用
+	fmt.Fprintf(c.conn, "*-1\r\n*0\r\n*-2147483648\r\n")
+	expect(t, c.do("SET", "k", "v"), "OK")
+	expect(t, c.do("GET", "k"), "v")
+
+	rr := newRespReader(strings.NewReader("*-1\r\n"))
+	if args, err := rr.ReadCommand(); args != nil || err != nil {
+		t.Error("null array should be ignored", args, err)
+	}
+}
+
+//声明很大的参数长度但不发送数据时，不应按声明的长度分配内存
+func TestHugeBulkHeader(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := newRespReader(strings.NewReader("*1\r\n$536870912\r\nabc")).ReadCommand()
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Error("truncated bulk should fail")
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Error("allocated too much for a bulk header", allocated)
+	}
+}
+
+func TestConcurrentIncr(t *testing.T) {
+	s, c := startServer(t, "TestConcurrentIncr")
+	defer s.Close()
+	addr := c.conn.RemoteAddr().String()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := dial(t, addr)
+			defer c.conn.Close()
+			for j := 0; j < 100; j++ {
+				c.do("INCR", "counter")
+			}
+		}()
+	}
+	wg.Wait()
+	expect(t, c.do("GET", "counter"), "1000")
+}
+
+//总是写入失败的write-through后端
+type failingWriter struct{}
+
+func (failingWriter) Write(ops []memory_cache.WriteOp) error {
+	return errors.New("backend down")
+}
+
+func TestWriteThroughError(t *testing.T) {
+	s, c := startServer(t, "TestWriteThroughError")
+	defer s.Close()
+	table := s.table(0)
+	table.Flush()
+	table.Add("k", []byte("1"), 0)
+	table.SetWriteThrough(failingWriter{})
+	defer table.SetWriteThrough(nil)
+
+	backendDown := fmt.Errorf("ERR backend down")
+	expect(t, c.do("SET", "k", "v"), backendDown)
+	expect(t, c.do("SET", "new", "v", "NX"), backendDown)
+	expect(t, c.do("SET", "k", "v", "XX"), backendDown)
+	expect(t, c.do("INCR", "k"), backendDown)
+	expect(t, c.do("INCR", "counter"), backendDown)
+	expect(t, c.do("DEL", "k"), backendDown)
+	expect(t, c.do("DEL", "missing"), int64(0))
+	expect(t, c.do("GET", "k"), "1")
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "a/b", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*llo", "hello", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyyd", false},
+		{"*[0-9]?", "key12", true},
+		{"[", "[", true},
+	}
+	for _, c := range cases {
+		if matchPattern(c.pattern, c.s) != c.match {
+			t.Error("wrong match result", c.pattern, c.s)
+		}
+	}
+
+	//回溯是线性的，多个*不会导致指数级的匹配时间
+	start := time.Now()
+	if matchPattern(strings.Repeat("a*", 30)+"b", strings.Repeat("a", 100)) {
+		t.Error("pattern should not match")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("pattern matching is too slow", elapsed)
+	}
+}
+
+func TestIncrKeepsTTL(t *testing.T) {
+	s, c := startServer(t, "TestIncrKeepsTTL")
+	defer s.Close()
+	table := s.table(0)
+	table.Flush()
+	table.SetLifeSpanJitter(50)
+	defer table.SetLifeSpanJitter(0)
+
+	expect(t, c.do("SET", "n", "1", "PX", "10000"), "OK")
+	before := c.do("PTTL", "n").(int64)
+	for i := 0; i < 5; i++ {
+		c.do("INCR", "n")
+	}
+	expect(t, c.do("GET", "n"), "6")
+	if after := c.do("PTTL", "n").(int64); after > before || after < before-1000 {
+		t.Error("INCR should keep the remaining ttl", before, after)
+	}
+	expect(t, c.do("SET", "forever", "1"), "OK")
+	c.do("INCR", "forever")
+	expect(t, c.do("PTTL", "forever"), int64(-1))
+}
+
+func TestClose(t *testing.T) {
+	s, c := startServer(t, "TestClose")
+	expect(t, c.do("PING"), "PONG")
+	s.Close()
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("connection should be closed after server Close")
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	if err := s.Serve(l); err != ErrServerClosed {
+		t.Error("Serve after Close should fail", err)
+	}
+}
@@ -0,0 +1,269 @@
+//Package sessions 把CacheTable用作HTTP会话存储。
+//
+//CacheTable的Value会刷新item的访问时间，正好是会话的滑动过期：只要用户持续访问，会话就不会过期。
+//Middleware从cookie中取出会话ID并加载会话，处理函数通过FromContext读写当前请求的会话，
+//请求结束时修改过的会话被写回table。
+//
+//会话在请求开始时复制一份，同一个会话的并发请求互不影响，写回时后结束的请求覆盖先结束的。
+//新建、Regenerate和Destroy都需要设置cookie，应在写响应之前调用。
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+const (
+	DefaultCookieName = "session_id"
+	DefaultLifeSpan   = 30 * time.Minute
+	idBytes           = 32
+)
+
+type Options struct {
+	CookieName string        //为空时使用DefaultCookieName
+	LifeSpan   time.Duration //会话空闲多久后过期，<=0时使用DefaultLifeSpan
+	Path       string        //cookie的Path，为空时为/
+	Domain     string
+	Secure     bool            //只通过HTTPS发送cookie
+	SameSite   http.SameSite   //为0时使用http.SameSiteLaxMode
+	OnError    func(err error) //写回会话失败时的回调，此时响应已经发出
+}
+
+type Store struct {
+	table *memory_cache.CacheTable
+	opts  Options
+}
+
+//table应只用于保存会话，会话数据的类型为map[string]interface{}
+func NewStore(table *memory_cache.CacheTable, opts Options) *Store {
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+	if opts.LifeSpan <= 0 {
+		opts.LifeSpan = DefaultLifeSpan
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	return &Store{table: table, opts: opts}
+}
+
+type contextKey struct{}
+
+//当前请求的会话，没有经过Middleware时返回nil
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+func (store *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := store.load(r)
+		sw := &responseWriter{ResponseWriter: w, session: s}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+		if !sw.wroteHeader { //处理函数没有写响应时，响应头在返回后才发出
+			s.writeCookie(w)
+		}
+		if err := s.save(); err != nil && store.opts.OnError != nil {
+			store.opts.OnError(err)
+		}
+	})
+}
+
+func (store *Store) load(r *http.Request) *Session {
+	s := &Session{store: store}
+	if c, err := r.Cookie(store.opts.CookieName); err == nil && c.Value != "" {
+		//Value刷新访问时间，即滑动过期
+		if item, err := store.table.Value(c.Value); err == nil {
+			if values, ok := item.Data().(map[string]interface{}); ok {
+				s.id = c.Value
+				s.values = make(map[string]interface{}, len(values))
+				for k, v := range values {
+					s.values[k] = v
+				}
+				return s
+			}
+		}
+	}
+	s.values = make(map[string]interface{})
+	return s
+}
+
+//一次请求内的会话，可以在处理函数的多个goroutine中使用
+type Session struct {
+	store *Store
+
+	mu          sync.Mutex
+	id          string //新会话在第一次修改时才分配ID
+	values      map[string]interface{}
+	oldID       string //Regenerate之前的ID，写回时删除
+	dirty       bool
+	destroyed   bool
+	cookieDirty bool  //需要发送Set-Cookie
+	idErr       error //分配ID失败时会话不会被写回
+}
+
+//会话ID，新会话没有修改过时为空
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.markDirty()
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.markDirty()
+	}
+}
+
+//立即删除会话并让浏览器删除cookie，之后的Set会开启一个新会话
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		s.store.table.Delete(s.id)
+	}
+	s.id = ""
+	s.values = make(map[string]interface{})
+	s.dirty = false
+	s.destroyed = true
+	s.cookieDirty = true
+}
+
+//保留会话数据换一个新ID，登录等权限变化后调用以防止会话固定攻击
+func (s *Session) Regenerate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = id
+	s.dirty = true
+	s.destroyed = false
+	s.cookieDirty = true
+	return nil
+}
+
+//调用方需持有s.mu
+func (s *Session) markDirty() {
+	s.dirty = true
+	s.destroyed = false
+	if s.id == "" {
+		s.id, s.idErr = newID()
+		s.cookieDirty = s.idErr == nil
+	}
+}
+
+func (s *Session) writeCookie(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cookieDirty {
+		return
+	}
+	opts := s.store.opts
+	c := &http.Cookie{
+		Name:     opts.CookieName,
+		Value:    s.id,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   opts.Secure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	}
+	if s.id == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+	s.cookieDirty = false
+}
+
+//把修改过的会话写回table
+func (s *Session) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID != "" {
+		s.store.table.Delete(s.oldID)
+		s.oldID = ""
+	}
+	if !s.dirty {
+		return nil
+	}
+	if s.id == "" {
+		return s.idErr
+	}
+	values := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	if _, err := s.store.table.Put(s.id, values, s.store.opts.LifeSpan); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+//32字节的随机数，URL安全的base64编码
+func newID() (string, error) {
+	buf := make([]byte, idBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//第一次写响应头时发送会话cookie
+type responseWriter struct {
+	http.ResponseWriter
+	session     *Session
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.session.writeCookie(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//供http.ResponseController访问底层的ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+//每个请求按path执行一个操作，响应体为会话中count的值
+func newServer(table *memory_cache.CacheTable, opts Options) http.Handler {
+	store := NewStore(table, opts)
+	return store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		switch r.URL.Path {
+		case "/incr":
+			n, _ := s.Get("count")
+			count, _ := n.(int)
+			s.Set("count", count+1)
+		case "/login":
+			if err := s.Regenerate(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "/logout":
+			s.Destroy()
+		case "/silent": //不写响应，cookie在处理函数返回后设置
+			s.Set("count", 100)
+			return
+		}
+		n, _ := s.Get("count")
+		fmt.Fprint(w, n)
+	}))
+}
+
+func request(h http.Handler, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	r := httptest.NewRequest("GET", path, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == DefaultCookieName {
+			return w, c
+		}
+	}
+	return w, nil
+}
+
+func TestSession(t *testing.T) {
+	table := memory_cache.Cache("TestSession")
+	table.Flush()
+	h := newServer(table, Options{Secure: true})
+
+	//没有修改的新会话不发cookie也不保存
+	if _, c := request(h, "/", nil); c != nil || table.Count() != 0 {
+		t.Fatal("untouched session should not be issued", c)
+	}
+
+	w, cookie := request(h, "/incr", nil)
+	if cookie == nil || len(cookie.Value) < 40 || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatal("new session cookie", cookie)
+	}
+	if w.Body.String() != "1" {
+		t.Fatal("body", w.Body.String())
+	}
+	w, c := request(h, "/incr", cookie)
+	if w.Body.String() != "2" || c != nil {
+		t.Fatal("existing session should be loaded without a new cookie", w.Body.String(), c)
+	}
+
+	//未知的ID不会被采用
+	if _, c := request(h, "/incr", &http.Cookie{Name: DefaultCookieName, Value: "forged"}); c == nil || c.Value == "forged" {
+		t.Error("unknown session id should be replaced", c)
+	}
+
+	w, _ = request(h, "/silent", nil)
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("cookie should be set when handler writes nothing")
+	}
+}
+
+func TestRegenerateAndDestroy(t *testing.T) {
+	table := memory_cache.Cache("TestRegenerateAndDestroy")
+	table.Flush()
+	h := newServer(table, Options{})
+	_, cookie := request(h, "/incr", nil)
+
+	w, renewed := request(h, "/login", cookie)
+	if renewed == nil || renewed.Value == cookie.Value || w.Body.String() != "1" {
+		t.Fatal("regenerate should keep data under a new id", renewed, w.Body.String())
+	}
+	if table.Exists(cookie.Value) || !table.Exists(renewed.Value) {
+		t.Error("old session id should be removed")
+	}
+
+	w, c := request(h, "/logout", renewed)
+	if c == nil || c.MaxAge >= 0 || table.Exists(renewed.Value) {
+		t.Error("destroy should delete the session and expire the cookie", c)
+	}
+	if w, _ = request(h, "/", renewed); w.Body.String() != "<nil>" {
+		t.Error("destroyed session should not be loaded", w.Body.String())
+	}
+}
+
+func TestSlidingExpiry(t *testing.T) {
+	table := memory_cache.Cache("TestSlidingExpiry")
+	table.Flush()
+	h := newServer(table, Options{LifeSpan: 100 * time.Millisecond})
+	_, cookie := request(h, "/incr", nil)
+	//只读的请求也会延长会话
+	for i := 0; i < 4; i++ {
+		time.Sleep(60 * time.Millisecond)
+		if w, _ := request(h, "/", cookie); w.Body.String() != "1" {
+			t.Fatal("session expired while in use", i)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if w, _ := request(h, "/", cookie); w.Body.String() != "<nil>" {
+		t.Error("idle session should expire", w.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package tiered
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//基于本地目录的L2存储，每个key一个文件，文件名为key的sha1
+//文件内容为8字节的过期时间(UnixNano，0表示永不过期)加上value
+//写入先写临时文件再rename，多个进程共享同一目录时读到的总是完整的value
+//Get遇到过期的文件只当作未命中，不删除：删除和其他进程并发的Set之间无法加锁，可能删掉刚写入的新value
+//过期文件由Cleanup删除
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *FileStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, ErrMiss //损坏的文件按未命中处理
+	}
+	if expires := int64(binary.BigEndian.Uint64(data)); expires != 0 && time.Now().UnixNano() >= expires {
+		return nil, ErrMiss
+	}
+	return data[8:], nil
+}
+
+func (s *FileStore) Set(key string, value []byte, ttl time.Duration) error {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	data := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(data, uint64(expires))
+	copy(data[8:], value)
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//删除目录中所有已过期的文件，返回删除的数量，可以定期调用
+func (s *FileStore) Cleanup() (int, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixNano()
+	removed := 0
+	for _, fi := range files {
+		if fi.IsDir() || len(fi.Name()) != sha1.Size*2 {
+			continue
+		}
+		path := filepath.Join(s.dir, fi.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		var header [8]byte
+		_, err = io.ReadFull(f, header[:])
+		info, statErr := f.Stat()
+		f.Close()
+		if err != nil || statErr != nil {
+			continue
+		}
+		if expires := int64(binary.BigEndian.Uint64(header[:])); expires != 0 && now >= expires {
+			//Set通过rename替换文件，读取之后文件被替换时跳过，不删除新写入的value
+			if cur, err := os.Stat(path); err != nil || !os.SameFile(info, cur) {
+				continue
+			}
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
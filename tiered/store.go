@@ -0,0 +1,23 @@
+//Package tiered 让CacheTable作为L1位于一个较慢的共享L2存储之前。
+//
+//读取依次查询L1、L2和用户的loadData，L2命中时回填L1，loadData加载的值同时写入L2。
+//写入按WriteMode同步(write-through)或延迟合并(write-back)写入L2，删除同时作用于两层。
+package tiered
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrMiss   = errors.New("tiered: key not found in L2 store")
+	ErrClosed = errors.New("tiered: table closed")
+)
+
+//L2存储，value为序列化后的字节，ttl<=0表示永不过期
+//Get在key不存在或已过期时返回ErrMiss，Delete不存在的key不算错误
+type L2Store interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
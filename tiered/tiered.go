@@ -0,0 +1,205 @@
+package tiered
+
+import (
+	"sync"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+type WriteMode int
+
+const (
+	WriteThrough WriteMode = iota //Add同步写入L2，写入失败时Add返回错误
+	WriteBack                     //Add只写L1，之后由后台按WriteBackInterval合并写入L2
+)
+
+const DefaultWriteBackInterval = 100 * time.Millisecond
+
+type Options struct {
+	Mode              WriteMode
+	L1LifeSpan        time.Duration                                                      //从L2回填到L1的item的生命周期
+	LoadData          func(key interface{}, args ...interface{}) *memory_cache.CacheItem //两层都未命中时调用，与CacheTable.SetLoadData相同
+	WriteBackInterval time.Duration                                                      //write-back的合并间隔，<=0时使用DefaultWriteBackInterval
+	OnError           func(key string, err error)                                        //后台写入以及读取L2失败时的回调
+}
+
+//两级缓存，只支持string类型的key和[]byte类型的value
+type Table struct {
+	table *memory_cache.CacheTable
+	store L2Store
+	opts  Options
+
+	mu      sync.Mutex
+	pending map[string]pendingWrite //等待write-back的写入，同一个key只保留最后一次
+	flushMu sync.Mutex              //串行化写回与删除，避免删除后又被旧的写回覆盖
+	stop    chan struct{}
+	done    chan struct{}
+
+	closeOnce sync.Once
+	closed    bool //由mu保护，关闭后拒绝新的写入，保证write-back的写入都能被Close写回
+}
+
+type pendingWrite struct {
+	value    []byte
+	lifeSpan time.Duration
+}
+
+//接管table的loadData，之后应通过Table读写
+func New(table *memory_cache.CacheTable, store L2Store, opts Options) *Table {
+	if opts.WriteBackInterval <= 0 {
+		opts.WriteBackInterval = DefaultWriteBackInterval
+	}
+	t := &Table{
+		table:   table,
+		store:   store,
+		opts:    opts,
+		pending: make(map[string]pendingWrite),
+	}
+	table.SetLoadData(t.load)
+	if opts.Mode == WriteBack {
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.writeBackLoop()
+	}
+	return t
+}
+
+//底层的L1 table
+func (t *Table) L1() *memory_cache.CacheTable {
+	return t.table
+}
+
+func (t *Table) reportError(key string, err error) {
+	if t.opts.OnError != nil {
+		t.opts.OnError(key, err)
+	}
+}
+
+//L1未命中时由CacheTable.Value调用
+func (t *Table) load(key interface{}, args ...interface{}) *memory_cache.CacheItem {
+	if k, ok := key.(string); ok {
+		value, err := t.store.Get(k)
+		if err == nil {
+			return memory_cache.NewCacheItem(k, value, t.opts.L1LifeSpan)
+		}
+		if err != ErrMiss {
+			t.reportError(k, err)
+		}
+	}
+	if t.opts.LoadData == nil {
+		return nil
+	}
+	item := t.opts.LoadData(key, args...)
+	if item == nil {
+		return nil
+	}
+	//加载的值写入L2，其他共享L2的实例不必再次加载
+	k, ok1 := key.(string)
+	value, ok2 := item.Data().([]byte)
+	if ok1 && ok2 {
+		if err := t.write(k, value, item.LifeSpan()); err != nil {
+			t.reportError(k, err)
+		}
+	}
+	return item
+}
+
+//依次读取L1、L2和LoadData
+func (t *Table) Value(key string, args ...interface{}) (*memory_cache.CacheItem, error) {
+	return t.table.Value(key, args...)
+}
+
+//写入L1，并按WriteMode写入L2，Close之后返回ErrClosed
+//write-through写入L2失败时撤销本次写入L1的值；写入L2期间L1中的值可能短暂地领先于L2
+//撤销只删除本次写入的item，期间被并发的Add覆盖时保留新的值
+func (t *Table) Add(key string, value []byte, lifeSpan time.Duration) (*memory_cache.CacheItem, error) {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	item, err := t.table.Put(key, value, lifeSpan)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.write(key, value, item.LifeSpan()); err != nil {
+		t.table.CompareAndDelete(item)
+		return nil, err
+	}
+	return item, nil
+}
+
+func (t *Table) write(key string, value []byte, lifeSpan time.Duration) error {
+	if t.opts.Mode == WriteThrough {
+		return t.store.Set(key, value, lifeSpan)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed { //Close已经开始写回剩余的数据，之后加入的写入不会再被写回
+		return ErrClosed
+	}
+	t.pending[key] = pendingWrite{value: value, lifeSpan: lifeSpan}
+	return nil
+}
+
+//从两层中删除，尚未写回的写入会被丢弃
+func (t *Table) Delete(key string) error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+	t.mu.Lock()
+	delete(t.pending, key)
+	t.mu.Unlock()
+	t.table.Delete(key)
+	return t.store.Delete(key)
+}
+
+//立即把所有等待write-back的写入写入L2，返回第一个错误
+func (t *Table) Flush() error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[string]pendingWrite)
+	t.mu.Unlock()
+
+	var firstErr error
+	for key, w := range batch {
+		if err := t.store.Set(key, w.value, w.lifeSpan); err != nil {
+			t.reportError(key, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (t *Table) writeBackLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.WriteBackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+//停止后台写回并写入剩余的数据，之后的Add返回ErrClosed，可以重复和并发调用
+func (t *Table) Close() error {
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		t.mu.Unlock()
+		if t.stop != nil {
+			close(t.stop)
+			<-t.done
+		}
+	})
+	return t.Flush()
+}
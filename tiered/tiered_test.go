@@ -0,0 +1,276 @@
+package tiered
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	memory_cache "github.com/TonyXMH/MemoryCache"
+)
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("missing"); err != ErrMiss {
+		t.Error("expected miss", err)
+	}
+	if err := s.Set("k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("k"); err != nil || string(v) != "v" {
+		t.Error("unexpected value", string(v), err)
+	}
+	s.Set("short", []byte("x"), 20*time.Millisecond)
+	s.Set("short2", []byte("x"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, err := s.Get("short"); err != ErrMiss {
+		t.Error("expired value should miss", err)
+	}
+	if n, err := s.Cleanup(); err != nil || n != 2 {
+		t.Error("cleanup should remove both expired files", n, err)
+	}
+	if err := s.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("k"); err != nil {
+		t.Error("deleting a missing key is not an error", err)
+	}
+	if _, err := s.Get("k"); err != ErrMiss {
+		t.Error("deleted key should miss", err)
+	}
+}
+
+//过期的文件被并发的Set替换后，Get和Cleanup都不能删除新的value
+func TestFileStoreExpiredReplaced(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("k", []byte("old"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get("k"); err != ErrMiss {
+		t.Error("expired value should miss", err)
+	}
+	s.Set("k", []byte("new"), 0)
+	if n, err := s.Cleanup(); err != nil || n != 0 {
+		t.Error("cleanup should not remove a live file", n, err)
+	}
+	if v, err := s.Get("k"); err != nil || string(v) != "new" {
+		t.Error("new value should survive", string(v), err)
+	}
+}
+
+//记录调用次数并可以注入错误的L2
+type countingStore struct {
+	L2Store
+	mu   sync.Mutex
+	sets int
+	fail error
+}
+
+func (s *countingStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.sets++
+	return s.L2Store.Set(key, value, ttl)
+}
+
+func (s *countingStore) setCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sets
+}
+
+func TestReadPath(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFileStore(dir)
+	loads := 0
+	opts := Options{
+		L1LifeSpan: time.Minute,
+		LoadData: func(key interface{}, args ...interface{}) *memory_cache.CacheItem {
+			loads++
+			if key == "absent" {
+				return nil
+			}
+			return memory_cache.NewCacheItem(key, []byte("loaded"), time.Minute)
+		},
+	}
+	a := New(memory_cache.Cache("tiered_read_a"), fs, opts)
+	b := New(memory_cache.Cache("tiered_read_b"), fs, opts)
+
+	//a加载后写入L2，b从L2读取而不再加载
+	if item, err := a.Value("k"); err != nil || string(item.Data().([]byte)) != "loaded" {
+		t.Fatal("unexpected load", err)
+	}
+	if item, err := b.Value("k"); err != nil || string(item.Data().([]byte)) != "loaded" {
+		t.Fatal("unexpected L2 read", err)
+	}
+	if loads != 1 {
+		t.Error("L2 hit should not call LoadData", loads)
+	}
+	if !b.L1().Exists("k") {
+		t.Error("L2 hit should populate L1")
+	}
+	if _, err := a.Value("absent"); err != memory_cache.ErrNotFoundOrLoadable {
+		t.Error("expected not loadable", err)
+	}
+
+	//删除同时作用于两层
+	if err := a.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if a.L1().Exists("k") {
+		t.Error("delete should remove from L1")
+	}
+	if _, err := fs.Get("k"); err != ErrMiss {
+		t.Error("delete should remove from L2", err)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	store := &countingStore{L2Store: fs}
+	tt := New(memory_cache.Cache("tiered_through"), store, Options{})
+	if _, err := tt.Add("k", []byte("v1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := fs.Get("k"); err != nil || string(v) != "v1" {
+		t.Error("write-through should reach L2", string(v), err)
+	}
+
+	store.fail = errors.New("l2 down")
+	if _, err := tt.Add("k", []byte("v2"), 0); err == nil {
+		t.Fatal("L2 failure should fail Add")
+	}
+	if tt.L1().Exists("k") {
+		t.Error("L1 should not keep a value that failed to reach L2")
+	}
+	store.fail = nil
+	if item, err := tt.Value("k"); err != nil || string(item.Data().([]byte)) != "v1" {
+		t.Error("old value should be read back from L2", err)
+	}
+}
+
+//第一次Set阻塞到release，然后失败
+type gateStore struct {
+	L2Store
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gateStore) Set(key string, value []byte, ttl time.Duration) error {
+	if atomic.AddInt32(&s.calls, 1) == 1 {
+		close(s.started)
+		<-s.release
+		return errors.New("l2 down")
+	}
+	return s.L2Store.Set(key, value, ttl)
+}
+
+//写入L2失败的Add只撤销自己写入L1的值，不删除并发的Add写入的新值
+func TestWriteThroughFailureKeepsNewerValue(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	store := &gateStore{L2Store: fs, started: make(chan struct{}), release: make(chan struct{})}
+	table := memory_cache.Cache("tiered_through_race")
+	table.Flush()
+	tt := New(table, store, Options{})
+	failed := make(chan error)
+	go func() {
+		_, err := tt.Add("k", []byte("old"), 0)
+		failed <- err
+	}()
+	<-store.started
+	if _, err := tt.Add("k", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	close(store.release)
+	if err := <-failed; err == nil {
+		t.Error("L2 failure should fail Add")
+	}
+	if item, err := table.Peek("k"); err != nil || string(item.Data().([]byte)) != "new" {
+		t.Error("newer value should stay in L1", err)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	store := &countingStore{L2Store: fs}
+	var errs []string
+	tt := New(memory_cache.Cache("tiered_back"), store, Options{
+		Mode:              WriteBack,
+		WriteBackInterval: time.Hour, //只通过Flush和Close写回
+		OnError:           func(key string, err error) { errs = append(errs, key) },
+	})
+	for i := 0; i < 10; i++ {
+		tt.Add("hot", []byte{byte(i)}, 0)
+	}
+	tt.Add("dropped", []byte("x"), 0)
+	tt.Delete("dropped")
+	if store.setCount() != 0 {
+		t.Fatal("write-back should not write synchronously")
+	}
+	if err := tt.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if store.setCount() != 1 {
+		t.Error("writes to one key should be coalesced", store.setCount())
+	}
+	if v, _ := fs.Get("hot"); len(v) != 1 || v[0] != 9 {
+		t.Error("last write should win", v)
+	}
+	if _, err := fs.Get("dropped"); err != ErrMiss {
+		t.Error("deleted key should not be written back", err)
+	}
+
+	store.fail = errors.New("l2 down")
+	tt.Add("k", []byte("v"), 0)
+	if err := tt.Close(); err == nil || len(errs) != 1 {
+		t.Error("write-back errors should be reported", err, errs)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	tt := New(memory_cache.Cache("tiered_close"), fs, Options{Mode: WriteBack})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tt.Close()
+		}()
+	}
+	wg.Wait()
+	if err := tt.Close(); err != nil {
+		t.Error("closing again should not fail", err)
+	}
+	//关闭后的写入不会再被写回，直接拒绝
+	if _, err := tt.Add("k", []byte("v"), 0); err != ErrClosed || tt.L1().Exists("k") {
+		t.Error("Add after Close should fail", err)
+	}
+}
+
+func TestWriteBackInterval(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	tt := New(memory_cache.Cache("tiered_interval"), fs, Options{Mode: WriteBack, WriteBackInterval: 10 * time.Millisecond})
+	defer tt.Close()
+	tt.Add("k", []byte("v"), 0)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := fs.Get("k"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("value was not written back")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
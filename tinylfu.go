@@ -0,0 +1,327 @@
+package memory_cache
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+)
+
+//W-TinyLFU：新key先进入占容量1%的LRU窗口，被挤出窗口后作为候选者，
+//只有估计的访问频率高于主区(SLRU)的淘汰对象时才被准入，否则直接淘汰候选者。
+//这样只访问一次的key(例如一次全表扫描)无法把经常访问的key挤出缓存。
+//频率由count-min sketch估计，前面加一个doorkeeper布隆过滤器过滤只出现一次的key，
+//记录的次数达到容量的10倍时所有计数减半并清空doorkeeper，使频率随时间衰减。
+
+const (
+	sketchDepth   = 4
+	maxSketchFreq = 15 //与4位计数器相同的上限，足以区分冷热
+)
+
+var hashSeed = maphash.MakeSeed()
+
+//key的64位hash，常见类型不产生分配，其他类型按其字符串形式计算
+func hashKey(key interface{}) uint64 {
+	if h, ok := intHash(key); ok {
+		return h
+	}
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	if s, ok := key.(string); ok {
+		h.WriteString(s)
+	} else {
+		h.WriteString(fmt.Sprint(key))
+	}
+	return h.Sum64()
+}
+
+//整数类型的key直接打散，不经过字符串
+func intHash(key interface{}) (uint64, bool) {
+	switch k := key.(type) {
+	case int:
+		return mix64(uint64(k)), true
+	case int64:
+		return mix64(uint64(k)), true
+	case int32:
+		return mix64(uint64(k)), true
+	case uint:
+		return mix64(uint64(k)), true
+	case uint64:
+		return mix64(k), true
+	case uint32:
+		return mix64(uint64(k)), true
+	}
+	return 0, false
+}
+
+//splitmix64的最后一步，打散整数key
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+//第i个位置由两个hash组合得到(double hashing)
+func hashIndex(h uint64, i int, mask uint64) uint64 {
+	return (h + uint64(i)*(h>>32|1)) & mask
+}
+
+type countMinSketch struct {
+	rows [sketchDepth][]uint8
+	mask uint64
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	width = nextPowerOfTwo(width)
+	s := &countMinSketch{mask: uint64(width - 1)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) increment(h uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][hashIndex(h, i, s.mask)]; *c < maxSketchFreq {
+			*c++
+		}
+	}
+}
+
+func (s *countMinSketch) estimate(h uint64) int {
+	min := maxSketchFreq
+	for i := range s.rows {
+		if c := int(s.rows[i][hashIndex(h, i, s.mask)]); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+//所有计数减半，实现频率的衰减
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+}
+
+func (s *countMinSketch) clear() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+}
+
+//只记录key是否出现过的布隆过滤器，使sketch只统计出现过两次以上的key
+type doorkeeper struct {
+	bits []uint64
+	mask uint64
+}
+
+func newDoorkeeper(n int) *doorkeeper {
+	size := nextPowerOfTwo(n * 8) //每个key约8位，误判率约2%
+	if size < 64 {
+		size = 64
+	}
+	return &doorkeeper{bits: make([]uint64, size/64), mask: uint64(size - 1)}
+}
+
+func (d *doorkeeper) contains(h uint64) bool {
+	for i := 0; i < 3; i++ {
+		bit := hashIndex(mix64(h), i, d.mask)
+		if d.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *doorkeeper) add(h uint64) {
+	for i := 0; i < 3; i++ {
+		bit := hashIndex(mix64(h), i, d.mask)
+		d.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (d *doorkeeper) clear() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+//key所在的区域
+const (
+	segWindow = iota
+	segProbation
+	segProtected
+)
+
+type tinyLFUEntry struct {
+	key  interface{}
+	hash uint64
+	seg  int
+}
+
+type tinyLFUPolicy struct {
+	windowCap    int
+	mainCap      int
+	protectedCap int
+
+	window    *list.List
+	probation *list.List //主区中只被访问过一次的key，淘汰对象从这里选
+	protected *list.List //主区中被再次访问过的key
+	elems     map[interface{}]*list.Element
+
+	sketch     *countMinSketch
+	door       *doorkeeper
+	additions  int
+	sampleSize int
+}
+
+func NewTinyLFUPolicy(capacity int) EvictionPolicy {
+	if capacity < 1 {
+		capacity = 1
+	}
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	p := &tinyLFUPolicy{
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * 80 / 100,
+		sketch:       newCountMinSketch(4 * capacity), //每行的宽度远大于key的数量，减少冲突导致的高估
+		door:         newDoorkeeper(capacity),
+		sampleSize:   10 * capacity,
+	}
+	p.Reset()
+	return p
+}
+
+//记录一次访问
+func (p *tinyLFUPolicy) record(h uint64) {
+	if p.door.contains(h) {
+		p.sketch.increment(h)
+	} else {
+		p.door.add(h)
+	}
+	p.additions++
+	if p.additions >= p.sampleSize {
+		p.sketch.age()
+		p.door.clear()
+		p.additions = 0
+	}
+}
+
+func (p *tinyLFUPolicy) frequency(h uint64) int {
+	freq := p.sketch.estimate(h)
+	if p.door.contains(h) {
+		freq++
+	}
+	return freq
+}
+
+func (p *tinyLFUPolicy) list(seg int) *list.List {
+	switch seg {
+	case segWindow:
+		return p.window
+	case segProbation:
+		return p.probation
+	}
+	return p.protected
+}
+
+func (p *tinyLFUPolicy) Add(key interface{}) []interface{} {
+	if _, ok := p.elems[key]; ok {
+		p.Access(key)
+		return nil
+	}
+	h := hashKey(key)
+	p.record(h)
+	p.elems[key] = p.window.PushFront(&tinyLFUEntry{key: key, hash: h, seg: segWindow})
+	if p.window.Len() <= p.windowCap {
+		return nil
+	}
+
+	//窗口满了，最早进入窗口的key成为候选者
+	back := p.window.Back()
+	p.window.Remove(back)
+	candidate := back.Value.(*tinyLFUEntry)
+	candidate.seg = segProbation
+	if p.probation.Len()+p.protected.Len() < p.mainCap {
+		p.elems[candidate.key] = p.probation.PushFront(candidate)
+		return nil
+	}
+
+	victimElem := p.probation.Back()
+	if victimElem == nil {
+		victimElem = p.protected.Back()
+	}
+	if victimElem == nil || p.frequency(candidate.hash) <= p.frequency(victimElem.Value.(*tinyLFUEntry).hash) {
+		delete(p.elems, candidate.key)
+		return []interface{}{candidate.key}
+	}
+	victim := victimElem.Value.(*tinyLFUEntry)
+	p.list(victim.seg).Remove(victimElem)
+	delete(p.elems, victim.key)
+	p.elems[candidate.key] = p.probation.PushFront(candidate)
+	return []interface{}{victim.key}
+}
+
+func (p *tinyLFUPolicy) Access(key interface{}) {
+	e, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*tinyLFUEntry)
+	p.record(entry.hash)
+	switch entry.seg {
+	case segWindow:
+		p.window.MoveToFront(e)
+	case segProbation: //再次访问后晋升到protected，protected满了就把最久没访问的降回probation
+		p.probation.Remove(e)
+		entry.seg = segProtected
+		p.elems[key] = p.protected.PushFront(entry)
+		if p.protected.Len() > p.protectedCap {
+			back := p.protected.Back()
+			p.protected.Remove(back)
+			demoted := back.Value.(*tinyLFUEntry)
+			demoted.seg = segProbation
+			p.elems[demoted.key] = p.probation.PushFront(demoted)
+		}
+	case segProtected:
+		p.protected.MoveToFront(e)
+	}
+}
+
+func (p *tinyLFUPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.list(e.Value.(*tinyLFUEntry).seg).Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+//清空所有key和频率信息
+func (p *tinyLFUPolicy) Reset() {
+	p.window = list.New()
+	p.probation = list.New()
+	p.protected = list.New()
+	p.elems = make(map[interface{}]*list.Element)
+	p.sketch.clear()
+	p.door.clear()
+	p.additions = 0
+}
@@ -0,0 +1,106 @@
+package memory_cache
+
+import "container/list"
+
+//2Q：新key先进入FIFO队列A1in，被挤出A1in时只把key记入ghost队列A1out；
+//在A1out中的key再次加入时说明它确实会被重复访问，直接进入LRU队列Am。
+//只访问一次的key始终停留在A1in中，无法挤掉Am中的热点key。
+
+const (
+	twoQIn = iota
+	twoQOut
+	twoQMain
+)
+
+type twoQEntry struct {
+	key   interface{}
+	where int
+}
+
+type twoQPolicy struct {
+	capacity int
+	inCap    int //A1in的大小，容量的25%
+	outCap   int //A1out记录的key数量，容量的50%
+	lists    [3]*list.List
+	elems    map[interface{}]*list.Element
+}
+
+func New2QPolicy(capacity int) EvictionPolicy {
+	if capacity < 1 {
+		capacity = 1
+	}
+	p := &twoQPolicy{
+		capacity: capacity,
+		inCap:    capacity / 4,
+		outCap:   capacity / 2,
+	}
+	if p.inCap < 1 {
+		p.inCap = 1
+	}
+	if p.outCap < 1 {
+		p.outCap = 1
+	}
+	p.Reset()
+	return p
+}
+
+func (p *twoQPolicy) push(key interface{}, where int) {
+	p.elems[key] = p.lists[where].PushFront(&twoQEntry{key: key, where: where})
+}
+
+func (p *twoQPolicy) remove(e *list.Element) *twoQEntry {
+	entry := e.Value.(*twoQEntry)
+	p.lists[entry.where].Remove(e)
+	delete(p.elems, entry.key)
+	return entry
+}
+
+func (p *twoQPolicy) Add(key interface{}) []interface{} {
+	if e, ok := p.elems[key]; ok {
+		switch e.Value.(*twoQEntry).where {
+		case twoQOut: //A1out中的key再次出现，进入Am
+			p.remove(e)
+			p.push(key, twoQMain)
+		default:
+			p.Access(key)
+			return nil
+		}
+	} else {
+		p.push(key, twoQIn)
+	}
+
+	var evicted []interface{}
+	for p.lists[twoQIn].Len()+p.lists[twoQMain].Len() > p.capacity {
+		if p.lists[twoQIn].Len() > p.inCap || p.lists[twoQMain].Len() == 0 {
+			entry := p.remove(p.lists[twoQIn].Back())
+			evicted = append(evicted, entry.key)
+			p.push(entry.key, twoQOut)
+			if p.lists[twoQOut].Len() > p.outCap {
+				p.remove(p.lists[twoQOut].Back())
+			}
+		} else {
+			evicted = append(evicted, p.remove(p.lists[twoQMain].Back()).key)
+		}
+	}
+	return evicted
+}
+
+//A1in是FIFO，只有Am中的key被访问时才调整顺序
+func (p *twoQPolicy) Access(key interface{}) {
+	if e, ok := p.elems[key]; ok && e.Value.(*twoQEntry).where == twoQMain {
+		p.lists[twoQMain].MoveToFront(e)
+	}
+}
+
+func (p *twoQPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok && e.Value.(*twoQEntry).where != twoQOut {
+		p.remove(e)
+	}
+}
+
+func (p *twoQPolicy) Reset() {
+	for i := range p.lists {
+		p.lists[i] = list.New()
+	}
+	p.elems = make(map[interface{}]*list.Element)
+}
@@ -0,0 +1,228 @@
+package memory_cache
+
+import (
+	"sync"
+	"time"
+)
+
+//把table的修改同步到后端存储
+//write-through模式下Add/Delete在写入成功后才修改table，后端I/O不持有table的锁，同一个key的修改按顺序串行；write-behind模式下修改先进入队列，
+//同一个key的多次修改合并为最后一次，由后台按批写入，失败时按指数退避重试
+//过期和Flush不是对数据的修改，不会通知Writer
+
+type WriteOpType int
+
+const (
+	WriteAdd    WriteOpType = iota + 1 //添加原本不存在的key
+	WriteUpdate                        //覆盖已存在的key
+	WriteDelete
+)
+
+func (t WriteOpType) String() string {
+	switch t {
+	case WriteAdd:
+		return "add"
+	case WriteUpdate:
+		return "update"
+	case WriteDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type WriteOp struct {
+	Type     WriteOpType
+	Key      interface{}
+	Data     interface{}   //WriteDelete时为nil
+	LifeSpan time.Duration //WriteDelete时为0
+}
+
+//后端存储，write-through时每次只有一个op，write-behind时为一批合并后的op
+type Writer interface {
+	Write(ops []WriteOp) error
+}
+
+type WriteBehindOptions struct {
+	MaxDelay     time.Duration                  //修改最多在队列中等待的时间，默认1秒
+	BatchSize    int                            //每批最多写入的op数量，队列达到该数量时立即写入，默认100
+	MaxRetries   int                            //失败后的重试次数，默认3次，<0表示不重试
+	RetryBackoff time.Duration                  //第一次重试前的等待时间，之后每次翻倍，默认100毫秒
+	OnError      func(ops []WriteOp, err error) //重试全部失败后调用，这批op会被丢弃
+}
+
+//设置write-through的Writer，w为nil时取消，会先关闭已有的write-behind
+func (table *CacheTable) SetWriteThrough(w Writer) {
+	table.CloseWriter()
+	table.Lock()
+	table.writer = w
+	table.Unlock()
+}
+
+//设置write-behind的Writer，会先关闭已有的Writer
+func (table *CacheTable) SetWriteBehind(w Writer, opts WriteBehindOptions) {
+	table.CloseWriter()
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+	b := &writeBehind{
+		w:     w,
+		opts:  opts,
+		index: make(map[interface{}]int),
+		kick:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go b.loop()
+	table.Lock()
+	table.writeBehind = b
+	table.Unlock()
+}
+
+//立即写入write-behind队列中所有的修改，返回最后一批失败的错误
+func (table *CacheTable) FlushWrites() error {
+	table.RLock()
+	b := table.writeBehind
+	table.RUnlock()
+	if b == nil {
+		return nil
+	}
+	return b.flush()
+}
+
+//取消Writer，write-behind会先停止后台写入并写完队列中剩余的修改，应在程序退出前调用
+func (table *CacheTable) CloseWriter() error {
+	table.Lock()
+	b := table.writeBehind
+	table.writer = nil
+	table.writeBehind = nil
+	table.Unlock()
+	if b == nil {
+		return nil
+	}
+	close(b.stop)
+	<-b.done
+	return b.flush()
+}
+
+//keyLock的分段数
+const keyLockShards = 64
+
+//write-through时key所在分段的锁，后端I/O期间同一分段的其他key也要等待，但不会阻塞table的读写
+func (table *CacheTable) keyLock(key interface{}) *sync.Mutex {
+	return &table.keyLocks[hashKey(key)&(keyLockShards-1)]
+}
+
+//把修改放入write-behind队列，调用方需持有table的写锁，保证op的顺序与table的修改顺序一致
+//write-through由addThrough和deleteThrough在table的锁之外处理
+func (table *CacheTable) enqueueWrite(op WriteOp) {
+	if table.writeBehind != nil {
+		table.writeBehind.enqueue(op)
+	}
+}
+
+type writeBehind struct {
+	w    Writer
+	opts WriteBehindOptions
+
+	mu      sync.Mutex
+	ops     []WriteOp
+	index   map[interface{}]int //key在ops中的位置，用于合并
+	existed []bool              //与ops对应，该key在入队前是否已经在后端
+
+	flushMu sync.Mutex //同一时刻只有一个flush，保证同一个key的修改按顺序写入
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (b *writeBehind) enqueue(op WriteOp) {
+	b.mu.Lock()
+	if i, ok := b.index[op.Key]; ok {
+		//合并后的类型取决于队列中第一个op之前后端是否已有这个key
+		if op.Type != WriteDelete {
+			op.Type = WriteAdd
+			if b.existed[i] {
+				op.Type = WriteUpdate
+			}
+		}
+		b.ops[i] = op
+	} else {
+		b.index[op.Key] = len(b.ops)
+		b.ops = append(b.ops, op)
+		b.existed = append(b.existed, op.Type != WriteAdd)
+	}
+	full := len(b.ops) >= b.opts.BatchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *writeBehind) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.MaxDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.kick:
+		case <-ticker.C:
+		}
+		b.flush()
+	}
+}
+
+func (b *writeBehind) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	ops := b.ops
+	b.ops = nil
+	b.existed = nil
+	b.index = make(map[interface{}]int)
+	b.mu.Unlock()
+
+	var lastErr error
+	for len(ops) > 0 {
+		n := b.opts.BatchSize
+		if n > len(ops) {
+			n = len(ops)
+		}
+		if err := b.writeWithRetry(ops[:n]); err != nil {
+			lastErr = err
+		}
+		ops = ops[n:]
+	}
+	return lastErr
+}
+
+func (b *writeBehind) writeWithRetry(batch []WriteOp) error {
+	backoff := b.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.w.Write(batch)
+		if err == nil {
+			return nil
+		}
+		if attempt >= b.opts.MaxRetries {
+			if b.opts.OnError != nil {
+				b.opts.OnError(batch, err)
+			}
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}